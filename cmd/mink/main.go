@@ -19,7 +19,6 @@ package main
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/mitchellh/go-homedir"
@@ -75,17 +74,19 @@ func init() {
 	rootCmd.AddCommand(command.NewBundleCommand())
 	rootCmd.AddCommand(command.NewBuildCommand())
 	rootCmd.AddCommand(command.NewBuildpackCommand())
+	rootCmd.AddCommand(command.NewRebaseCommand())
 
 	rootCmd.AddCommand(command.NewResolveCommand())
 	rootCmd.AddCommand(command.NewPackageCommand())
 	rootCmd.AddCommand(command.NewApplyCommand())
 
+	rootCmd.AddCommand(command.NewGCCommand())
+
 	cobra.OnInitialize(initViperConfig)
 }
 
 // initViperConfig reads in config file and ENV variables if set.
 func initViperConfig() {
-	searchpath := make([]string, 0, 10)
 	// Find home directory.
 	home, err := homedir.Dir()
 	if err != nil {
@@ -100,11 +101,12 @@ func initViperConfig() {
 	viper.SetConfigName(".mink")
 	viper.SetConfigType("yaml")
 
-	filename := ".mink.yaml"
-	if nearest := nearestConfig(filename); nearest != "" {
-		searchpath = append(searchpath, nearest)
+	searchpath, err := command.ConfigFiles()
+	if err != nil {
+		// avoid color since we don't know if it should be enabled yet
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	searchpath = append(searchpath, filepath.Join(home, filename))
 	// TODO(mattmoor): Consider adding a system-side file, e.g. /etc/mink.yaml
 
 	// Perform our own search handling in order to configure our own precedence.
@@ -143,25 +145,6 @@ func initViperConfig() {
 	viper.AutomaticEnv() // read in environment variables that match
 }
 
-func nearestConfig(filename string) string {
-	// Find working directory.
-	wd, err := os.Getwd()
-	if err != nil {
-		// avoid color since we don't know if it should be enabled yet
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
-
-	for ; wd != filepath.Dir(wd); wd = filepath.Dir(wd) {
-		p := filepath.Join(wd, filename)
-		if _, err := os.Stat(p); os.IsNotExist(err) {
-			continue
-		}
-		return p
-	}
-	return ""
-}
-
 func main() {
 	err := rootCmd.Execute()
 	if err != nil {
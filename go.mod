@@ -11,6 +11,7 @@ require (
 	github.com/google/go-containerregistry v0.1.4
 	github.com/jenkins-x/jx-helpers/v3 v3.0.14
 	github.com/mitchellh/go-homedir v1.1.0
+	github.com/pelletier/go-toml v1.8.0
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/viper v1.7.0
@@ -27,6 +28,7 @@ require (
 	k8s.io/klog/v2 v2.4.0 // indirect
 	knative.dev/pkg v0.0.0-20201119170152-e5e30edc364a
 	sigs.k8s.io/kustomize/kyaml v0.6.1
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
@@ -38,9 +40,7 @@ replace (
 )
 
 // For ko
-replace (
-	github.com/docker/docker => github.com/docker/docker v1.4.2-0.20190924003213-a8608b5b67c7
-)
+replace github.com/docker/docker => github.com/docker/docker v1.4.2-0.20190924003213-a8608b5b67c7
 
 replace (
 	k8s.io/api => k8s.io/api v0.18.8
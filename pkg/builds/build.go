@@ -58,6 +58,10 @@ func Run(ctx context.Context, image string, tr *tknv1beta1.TaskRun, opt *options
 	if err != nil {
 		return name.Digest{}, err
 	}
+	kubeclient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return name.Digest{}, err
+	}
 
 	for _, o := range opts {
 		cancel, err := o(ctx, tr)
@@ -71,13 +75,28 @@ func Run(ctx context.Context, image string, tr *tknv1beta1.TaskRun, opt *options
 	if err != nil {
 		return name.Digest{}, err
 	}
+	adoptCredentials(ctx, kubeclient, tr.Namespace, tr.Annotations, metav1.OwnerReference{
+		APIVersion: tknv1beta1.SchemeGroupVersion.String(),
+		Kind:       "TaskRun",
+		Name:       tr.Name,
+		UID:        tr.UID,
+	})
 
 	// TODO(mattmoor): From here down assumes opt.Follow, but if we want to have
 	// a --no-wait or something then we should have an early-out here.
 	defer client.TektonV1beta1().TaskRuns(tr.Namespace).Delete(context.Background(), tr.Name, metav1.DeleteOptions{})
 
+	// When we are interrupted, cancel the build before deleting it (and cleaning up
+	// after the options), so that its pod isn't left using the credentials.
+	namespace, trName := tr.Namespace, tr.Name
+	defer func() {
+		if ctx.Err() != nil {
+			cancelTaskRun(client, kubeclient, namespace, trName)
+		}
+	}()
+
 	opt.TaskrunName = tr.Name
-	if err := streamLogs(ctx, opt); err != nil {
+	if err := streamLogs(ctx, func() error { return taskrun.Run(opt) }); err != nil {
 		return name.Digest{}, err
 	}
 
@@ -103,9 +122,12 @@ func Run(ctx context.Context, image string, tr *tknv1beta1.TaskRun, opt *options
 		} else if !cond.IsTrue() {
 			continue
 		}
+		if !producesDigest(tr) {
+			return name.Digest{}, nil
+		}
 
 		for _, result := range tr.Status.TaskRunResults {
-			if result.Name != "IMAGE-DIGEST" {
+			if result.Name != DigestResult {
 				continue
 			}
 			value := strings.TrimSpace(result.Value)
@@ -121,12 +143,16 @@ func Run(ctx context.Context, image string, tr *tknv1beta1.TaskRun, opt *options
 	}
 }
 
-func streamLogs(ctx context.Context, opt *options.LogOptions) error {
-	// TODO(mattmoor): This should take a context so that it can be cancelled.
-	errCh := make(chan error)
+// streamLogs runs the provided log streaming function until it completes or the
+// context is cancelled.
+func streamLogs(ctx context.Context, run func() error) error {
+	// The tkn log streaming cannot itself be cancelled, so when the context is
+	// cancelled we leave it running, and it completes once the run is cancelled
+	// and its pods terminate.
+	errCh := make(chan error, 1)
 	go func() {
 		defer close(errCh)
-		errCh <- taskrun.Run(opt)
+		errCh <- run()
 	}()
 
 	select {
@@ -143,19 +169,28 @@ func streamLogs(ctx context.Context, opt *options.LogOptions) error {
 // for the container registry hosting the image we will publish to (and to which
 // the source is published).
 func WithServiceAccount(sa string, refs ...name.Reference) CancelableOption {
-	cfg, err := GetConfig("", "")
-	if err != nil {
-		log.Fatal("GetConfig() =", err)
-	}
-	client := kubernetes.NewForConfigOrDie(cfg)
-
 	return func(ctx context.Context, tr *tknv1beta1.TaskRun) (context.CancelFunc, error) {
 		if sa != "me" {
 			tr.Spec.ServiceAccountName = sa
 			return func() {}, nil
 		}
 
-		cfg := struct {
+		if IsDryRun(ctx) {
+			// Show where the credentials would be mounted, without uploading them.
+			mountCredentials(tr, tr.GenerateName+"me", tr.GenerateName+"me")
+			return func() {}, nil
+		}
+
+		cfg, err := GetConfig("", "")
+		if err != nil {
+			return nil, err
+		}
+		client, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		dockerConfig := struct {
 			Auths map[string]*authn.AuthConfig `json:"auths"`
 		}{
 			Auths: make(map[string]*authn.AuthConfig, len(refs)),
@@ -172,20 +207,17 @@ func WithServiceAccount(sa string, refs ...name.Reference) CancelableOption {
 				return nil, err
 			}
 			// Use the funny form so that it works with DockerHub.
-			cfg.Auths["https://"+ref.Context().RegistryStr()+"/v1/"] = auth
+			dockerConfig.Auths["https://"+ref.Context().RegistryStr()+"/v1/"] = auth
 		}
-		b, err := json.Marshal(cfg)
+		b, err := json.Marshal(dockerConfig)
 		if err != nil {
 			return nil, err
 		}
 
 		// Create a secret and service account for this build.
 		secret := &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				GenerateName: tr.GenerateName,
-				Namespace:    tr.Namespace,
-			},
-			Type: corev1.SecretTypeDockerConfigJson,
+			ObjectMeta: credentialsMeta(tr),
+			Type:       corev1.SecretTypeDockerConfigJson,
 			StringData: map[string]string{
 				corev1.DockerConfigJsonKey: string(b),
 			},
@@ -197,15 +229,12 @@ func WithServiceAccount(sa string, refs ...name.Reference) CancelableOption {
 		cleansecret := func() {
 			err := client.CoreV1().Secrets(secret.Namespace).Delete(context.Background(), secret.Name, metav1.DeleteOptions{})
 			if err != nil {
-				log.Printf("WARNING: Secret %q leaked, error cleaning up: %v", secret.Name, err)
+				log.Printf("WARNING: failed to clean up Secret %q (it is left to its owner or TTL): %v", secret.Name, err)
 			}
 		}
 
 		sa := &corev1.ServiceAccount{
-			ObjectMeta: metav1.ObjectMeta{
-				GenerateName: tr.GenerateName,
-				Namespace:    tr.Namespace,
-			},
+			ObjectMeta: credentialsMeta(tr),
 			// Support pulling kontext using the user credentials.
 			ImagePullSecrets: []corev1.LocalObjectReference{{
 				Name: secret.Name,
@@ -219,50 +248,18 @@ func WithServiceAccount(sa string, refs ...name.Reference) CancelableOption {
 		cleansa := func() {
 			err := client.CoreV1().ServiceAccounts(sa.Namespace).Delete(context.Background(), sa.Name, metav1.DeleteOptions{})
 			if err != nil {
-				log.Printf("WARNING: ServiceAccount %q leaked, error cleaning up: %v", sa.Name, err)
+				log.Printf("WARNING: failed to clean up ServiceAccount %q (it is left to its owner or TTL): %v", sa.Name, err)
 			}
 		}
 
-		tr.Spec.ServiceAccountName = sa.Name
-
-		// Mount the credentials secret as a volume.
-		//nolint:gosec Randomized to avoid collisions.
-		volumeName := fmt.Sprint("mink-creds-", rand.Uint64())
-		tr.Spec.TaskSpec.Volumes = append(tr.Spec.TaskSpec.Volumes, corev1.Volume{
-			Name: volumeName,
-			VolumeSource: corev1.VolumeSource{
-				Projected: &corev1.ProjectedVolumeSource{
-					Sources: []corev1.VolumeProjection{{
-						Secret: &corev1.SecretProjection{
-							LocalObjectReference: corev1.LocalObjectReference{
-								Name: secret.Name,
-							},
-							Items: []corev1.KeyToPath{{
-								Key:  corev1.DockerConfigJsonKey,
-								Path: "config.json",
-								// Mode defaults to 0644
-							}},
-						},
-					}},
-				},
-			},
-		})
-		// How we will mount the credentials into steps.
-		vm := corev1.VolumeMount{
-			Name:      volumeName,
-			MountPath: fmt.Sprint("/var/mink/creds/", rand.Uint64()), //nolint:gosec // Randomize to avoid hardcoding (weak ok)
-		}
+		mountCredentials(tr, sa.Name, secret.Name)
 
-		for i := range tr.Spec.TaskSpec.Steps {
-			for j, env := range tr.Spec.TaskSpec.Steps[i].Env {
-				if env.Name == "DOCKER_CONFIG" {
-					// When steps specify DOCKER_CONFIG, override it's value and attach our mount.
-					tr.Spec.TaskSpec.Steps[i].Env[j].Value = vm.MountPath
-					tr.Spec.TaskSpec.Steps[i].VolumeMounts = append(tr.Spec.TaskSpec.Steps[i].VolumeMounts, vm)
-					break
-				}
-			}
+		// Note the credentials on the TaskRun, so that they are adopted by it once created.
+		if tr.Annotations == nil {
+			tr.Annotations = make(map[string]string, 2)
 		}
+		tr.Annotations[SecretAnnotation] = secret.Name
+		tr.Annotations[ServiceAccountAnnotation] = sa.Name
 
 		return func() {
 			cleansa()
@@ -271,6 +268,51 @@ func WithServiceAccount(sa string, refs ...name.Reference) CancelableOption {
 	}
 }
 
+// mountCredentials configures the TaskRun to run as the named ServiceAccount, and
+// mounts the named docker config Secret into the steps that specify DOCKER_CONFIG.
+func mountCredentials(tr *tknv1beta1.TaskRun, saName, secretName string) {
+	tr.Spec.ServiceAccountName = saName
+
+	// Mount the credentials secret as a volume.
+	//nolint:gosec Randomized to avoid collisions.
+	volumeName := fmt.Sprint("mink-creds-", rand.Uint64())
+	tr.Spec.TaskSpec.Volumes = append(tr.Spec.TaskSpec.Volumes, corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{{
+					Secret: &corev1.SecretProjection{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: secretName,
+						},
+						Items: []corev1.KeyToPath{{
+							Key:  corev1.DockerConfigJsonKey,
+							Path: "config.json",
+							// Mode defaults to 0644
+						}},
+					},
+				}},
+			},
+		},
+	})
+	// How we will mount the credentials into steps.
+	vm := corev1.VolumeMount{
+		Name:      volumeName,
+		MountPath: fmt.Sprint("/var/mink/creds/", rand.Uint64()), //nolint:gosec // Randomize to avoid hardcoding (weak ok)
+	}
+
+	for i := range tr.Spec.TaskSpec.Steps {
+		for j, env := range tr.Spec.TaskSpec.Steps[i].Env {
+			if env.Name == "DOCKER_CONFIG" {
+				// When steps specify DOCKER_CONFIG, override it's value and attach our mount.
+				tr.Spec.TaskSpec.Steps[i].Env[j].Value = vm.MountPath
+				tr.Spec.TaskSpec.Steps[i].VolumeMounts = append(tr.Spec.TaskSpec.Steps[i].VolumeMounts, vm)
+				break
+			}
+		}
+	}
+}
+
 // GetConfig is forked out of sharedmain because linking knative.dev/pkg/metrics spews logs.
 func GetConfig(masterURL, kubeconfig string) (*rest.Config, error) {
 	if kubeconfig == "" {
@@ -19,14 +19,12 @@ package buildpacks
 import (
 	"context"
 	"fmt"
-	"log"
 	"math/rand"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
-	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/mattmoor/mink/pkg/builds"
 	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -46,6 +44,23 @@ const (
 	//  - Node.js: quay.io/boson/faas-nodejs-builder
 	//  - Go:      quay.io/boson/faas-go-builder
 	BuildpackImage = "docker.io/paketobuildpacks/builder:full"
+
+	prepareStepName       = "prepare"
+	platformSetupStepName = "platform-setup"
+	detectStepName        = "detect"
+	printPlanStepName     = "print-plan"
+	createStepName        = "create"
+	extractDigestStepName = "extract-digest"
+
+	// DefaultCacheImage is the CacheImage that asks for layers to be cached in an
+	// image derived from the target: its tag in the /cache sub-repository (which
+	// is also where kaniko caches), so that each tag (e.g. each platform) has
+	// its own cache.
+	DefaultCacheImage = "default"
+
+	platformVolumeName = "platform-dir"
+	layersVolumeName   = "layers-dir"
+	cacheVolumeName    = "empty-dir"
 )
 
 var (
@@ -75,42 +90,107 @@ type Options struct {
 
 	// Env is additional environment variables to pass to the build.
 	Env []corev1.EnvVar
+
+	// RunImage overrides the stack's run image on which the exported image is based.
+	RunImage string
+
+	// CacheImage is the image in which the lifecycle caches layers across builds,
+	// or DefaultCacheImage.
+	CacheImage string
+
+	// CachePVC is the name of a PersistentVolumeClaim in which the lifecycle caches
+	// layers across builds.  Without it (or a CacheImage), the cache only lasts
+	// for the build.  Only one of CacheImage and CachePVC may be set.
+	CachePVC string
+
+	// TrustBuilder runs the whole lifecycle in a single container via its creator,
+	// which has the registry credentials throughout, so the builder must be trusted.
+	// This is ignored when DetectOnly is set.
+	TrustBuilder bool
+
+	// DetectOnly stops the build after the detect step, printing the group of
+	// buildpacks that participate and their build plan, instead of exporting
+	// an image (so the TaskRun carries the builds.NoImageAnnotation).
+	DetectOnly bool
+
+	// AppDir is the directory holding the application source, which defaults
+	// to a randomized directory under /workspace into which the source is
+	// extracted.  This is set to /workspace when the lifecycle is run locally,
+	// since there the source has already been extracted.
+	AppDir string
 }
 
 // Build synthesizes a TaskRun definition that evaluates the buildpack lifecycle with the
-// given options over the provided kontext.
-func Build(ctx context.Context, sourceSteps []tknv1beta1.Step, target name.Tag, opt Options) *tknv1beta1.TaskRun {
+// given options over the provided kontext.  The builder is pinned by digest, and the
+// lifecycle runs as its user (owning the volumes through the builder's group, when it
+// has a non-root one, or else chowning them as root), with the newest Platform API
+// that both it and we support, failing when we cannot determine these.
+func Build(ctx context.Context, sourceSteps []tknv1beta1.Step, target name.Tag, opt Options) (*tknv1beta1.TaskRun, error) {
+	if opt.CacheImage != "" && opt.CachePVC != "" {
+		return nil, fmt.Errorf("only one of CacheImage and CachePVC may be set, got %q and %q", opt.CacheImage, opt.CachePVC)
+	}
+	builder, err := resolveBuilder(ctx, opt.Builder)
+	if err != nil {
+		return nil, err
+	}
+	builderImage := builder.image.String()
+
+	cacheVolume := corev1.VolumeSource{
+		EmptyDir: &corev1.EmptyDirVolumeSource{},
+	}
+	if opt.CachePVC != "" {
+		cacheVolume = corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: opt.CachePVC,
+			},
+		}
+	}
+	cacheArg := "-cache-dir=/cache"
+	var cacheEnv []corev1.EnvVar
+	if opt.CacheImage != "" {
+		cacheImage := opt.CacheImage
+		if cacheImage == DefaultCacheImage {
+			cacheImage = target.Context().String() + "/cache:" + target.TagStr()
+		}
+		cacheArg = "-cache-image=" + cacheImage
+		cacheEnv = []corev1.EnvVar{{
+			Name:  "DOCKER_CONFIG",
+			Value: "/tekton/home/.docker",
+		}}
+	}
+
 	volumes := []corev1.Volume{{
-		Name: "empty-dir",
-		VolumeSource: corev1.VolumeSource{
-			EmptyDir: &corev1.EmptyDirVolumeSource{},
-		},
+		Name:         cacheVolumeName,
+		VolumeSource: cacheVolume,
 	}, {
-		Name: "layers-dir",
+		Name: layersVolumeName,
 		VolumeSource: corev1.VolumeSource{
 			EmptyDir: &corev1.EmptyDirVolumeSource{},
 		},
 	}, {
-		Name: "platform-dir",
+		Name: platformVolumeName,
 		VolumeSource: corev1.VolumeSource{
 			EmptyDir: &corev1.EmptyDirVolumeSource{},
 		},
 	}}
 
 	volumeMounts := []corev1.VolumeMount{{
-		Name:      "platform-dir",
+		Name:      platformVolumeName,
 		MountPath: "/platform",
 	}, {
-		Name:      "layers-dir",
+		Name:      layersVolumeName,
 		MountPath: "/layers",
 	}, {
-		Name:      "empty-dir",
+		Name:      cacheVolumeName,
 		MountPath: "/cache",
 	}}
 
 	//nolint:gosec // crypto rand is not needed.
 	workspaceDirectory := fmt.Sprint("/workspace/", rand.Uint64())
-	user, group := determineUserAndGroup(opt.Builder)
+	if opt.AppDir != "" {
+		workspaceDirectory = opt.AppDir
+	}
+	user, group := builder.uid, builder.gid
 
 	pfSetupArgs := make([]string, 0, 2*(len(opt.Env)+1))
 	pfSetupArgs = append(pfSetupArgs,
@@ -129,14 +209,170 @@ func Build(ctx context.Context, sourceSteps []tknv1beta1.Step, target name.Tag,
 		RunAsGroup: &group,
 	}
 
-	return &tknv1beta1.TaskRun{
+	// lifecycle returns a step running the named binary of the builder's lifecycle
+	// with the Platform API we negotiated with it.
+	lifecycle := func(name, binary string, args []string, env ...corev1.EnvVar) tknv1beta1.Step {
+		return tknv1beta1.Step{
+			Container: corev1.Container{
+				Name:       name,
+				Image:      builderImage,
+				WorkingDir: workspaceDirectory,
+				Command:    []string{"/cnb/lifecycle/" + binary},
+				Args:       args,
+				Env: append([]corev1.EnvVar{{
+					Name:  "CNB_PLATFORM_API",
+					Value: builder.platformAPI,
+				}}, env...),
+				VolumeMounts: volumeMounts,
+			},
+		}
+	}
+	dockerConfigEnv := corev1.EnvVar{
+		Name:  "DOCKER_CONFIG",
+		Value: "/tekton/home/.docker",
+	}
+
+	// The exporter (or creator) publishes the image, whose digest we then extract.
+	exportArgs := []string{
+		"-app=" + workspaceDirectory,
+		"-layers=/layers",
+		cacheArg,
+	}
+	if opt.RunImage != "" {
+		exportArgs = append(exportArgs, "-run-image="+opt.RunImage)
+	}
+	exportArgs = append(exportArgs, target.Name())
+	extractDigestStep := tknv1beta1.Step{
+		Container: corev1.Container{
+			Name:         extractDigestStepName,
+			Image:        ExtractDigestImage.String(),
+			WorkingDir:   workspaceDirectory,
+			Args:         []string{"-output=/tekton/results/IMAGE-DIGEST"},
+			VolumeMounts: volumeMounts,
+		},
+	}
+	// The phases that run buildpacks read the env/ of the platform directory that
+	// the platform-setup step populates (which is rewritten when run locally).
+	platformArg := "-platform=/platform"
+	detectStep := lifecycle(detectStepName, "detector", []string{
+		"-app=" + workspaceDirectory,
+		platformArg,
+		"-group=/layers/group.toml",
+		"-plan=/layers/plan.toml",
+	})
+
+	var steps []tknv1beta1.Step
+	podTemplate := &tknv1beta1.PodTemplate{
+		EnableServiceLinks: ptr.Bool(false),
+	}
+	if group != 0 {
+		// Rather than chown the volumes as root, have them owned by the
+		// builder's group.
+		podTemplate.SecurityContext = &corev1.PodSecurityContext{
+			FSGroup: &group,
+		}
+	} else {
+		steps = append(steps, tknv1beta1.Step{
+			Container: corev1.Container{
+				Name:       prepareStepName,
+				Image:      "alpine",
+				WorkingDir: workspaceDirectory,
+				Command:    []string{"/bin/sh"},
+				Args: []string{
+					"-c",
+					fmt.Sprintf(strings.Join([]string{
+						`chown -R "%[1]d:%[2]d" "/tekton/home"`,
+						`chown -R "%[1]d:%[2]d" "/layers"`,
+						`chown -R "%[1]d:%[2]d" "/cache"`,
+						`chown -R "%[1]d:%[2]d" "/workspace"`,
+					}, " && "), user, group),
+				},
+				VolumeMounts: volumeMounts,
+			},
+		})
+	}
+	steps = append(steps, sourceStep, tknv1beta1.Step{
+		Container: corev1.Container{
+			Name:         platformSetupStepName,
+			Image:        PlatformSetupImage.String(),
+			Args:         pfSetupArgs,
+			WorkingDir:   workspaceDirectory,
+			VolumeMounts: volumeMounts,
+		},
+	})
+
+	switch {
+	case opt.DetectOnly:
+		steps = append(steps, detectStep, tknv1beta1.Step{
+			Container: corev1.Container{
+				Name:       printPlanStepName,
+				Image:      "alpine",
+				WorkingDir: workspaceDirectory,
+				Command:    []string{"/bin/sh"},
+				Args: []string{
+					"-c",
+					strings.Join([]string{
+						`echo "# /layers/group.toml"`,
+						`cat /layers/group.toml`,
+						`echo "# /layers/plan.toml"`,
+						`cat /layers/plan.toml`,
+					}, " && "),
+				},
+				VolumeMounts: volumeMounts,
+			},
+		})
+
+	case opt.TrustBuilder:
+		// The creator runs all of the phases in a single container, which
+		// has the registry credentials throughout.
+		steps = append(steps,
+			lifecycle(createStepName, "creator", append([]string{platformArg}, exportArgs...), dockerConfigEnv),
+			extractDigestStep,
+		)
+
+	default:
+		steps = append(steps,
+			detectStep,
+			lifecycle("analyze", "analyzer", []string{
+				"-layers=/layers",
+				"-group=/layers/group.toml",
+				"-analyzed=/layers/analyze.toml",
+				cacheArg,
+				target.Name(),
+			}, cacheEnv...),
+			lifecycle("restore", "restorer", []string{
+				"-group=/layers/group.toml",
+				"-layers=/layers",
+				cacheArg,
+			}, cacheEnv...),
+			lifecycle("build", "builder", []string{
+				"-app=" + workspaceDirectory,
+				platformArg,
+				"-layers=/layers",
+				"-group=/layers/group.toml",
+				"-plan=/layers/plan.toml",
+			}),
+			lifecycle("export", "exporter", append([]string{
+				"-group=/layers/group.toml",
+				"-analyzed=/layers/analyze.toml",
+			}, exportArgs...), dockerConfigEnv),
+			extractDigestStep,
+		)
+	}
+
+	tr := &tknv1beta1.TaskRun{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: "buildpack-",
+			Labels: map[string]string{
+				builds.ManagedByLabel: builds.ManagedBy,
+				builds.BuilderLabel:   "buildpack",
+			},
+			Annotations: map[string]string{
+				builds.ImageAnnotation: target.String(),
+			},
 		},
 		Spec: tknv1beta1.TaskRunSpec{
-			PodTemplate: &tknv1beta1.PodTemplate{
-				EnableServiceLinks: ptr.Bool(false),
-			},
+			PodTemplate: podTemplate,
 
 			TaskSpec: &tknv1beta1.TaskSpec{
 				Results: []tknv1beta1.TaskResult{{
@@ -144,152 +380,13 @@ func Build(ctx context.Context, sourceSteps []tknv1beta1.Step, target name.Tag,
 				}},
 
 				Volumes: volumes,
-
-				Steps: []tknv1beta1.Step{{
-					Container: corev1.Container{
-						Name:       "prepare",
-						Image:      "alpine",
-						WorkingDir: workspaceDirectory,
-						Command:    []string{"/bin/sh"},
-						Args: []string{
-							"-c",
-							fmt.Sprintf(strings.Join([]string{
-								`chown -R "%[1]d:%[2]d" "/tekton/home"`,
-								`chown -R "%[1]d:%[2]d" "/layers"`,
-								`chown -R "%[1]d:%[2]d" "/cache"`,
-								`chown -R "%[1]d:%[2]d" "/workspace"`,
-							}, " && "), user, group),
-						},
-						VolumeMounts: volumeMounts,
-					},
-				}, sourceStep, {
-					Container: corev1.Container{
-						Name:         "platform-setup",
-						Image:        PlatformSetupImage.String(),
-						Args:         pfSetupArgs,
-						WorkingDir:   workspaceDirectory,
-						VolumeMounts: volumeMounts,
-					},
-				}, {
-					Container: corev1.Container{
-						Name:       "detect",
-						Image:      opt.Builder,
-						WorkingDir: workspaceDirectory,
-						Command:    []string{"/cnb/lifecycle/detector"},
-						Args: []string{
-							"-app=" + workspaceDirectory,
-							"-group=/layers/group.toml",
-							"-plan=/layers/plan.toml",
-						},
-						VolumeMounts: volumeMounts,
-					},
-				}, {
-					Container: corev1.Container{
-						Name:       "analyze",
-						Image:      opt.Builder,
-						WorkingDir: workspaceDirectory,
-						Command:    []string{"/cnb/lifecycle/analyzer"},
-						Args: []string{
-							"-layers=/layers",
-							"-group=/layers/group.toml",
-							"-analyzed=/layers/analyze.toml",
-							"-cache-dir=/cache",
-							target.Name(),
-						},
-						VolumeMounts: volumeMounts,
-					},
-				}, {
-					Container: corev1.Container{
-						Name:       "restore",
-						Image:      opt.Builder,
-						WorkingDir: workspaceDirectory,
-						Command:    []string{"/cnb/lifecycle/restorer"},
-						Args: []string{
-							"-group=/layers/group.toml",
-							"-layers=/layers",
-							"-cache-dir=/cache",
-						},
-						VolumeMounts: volumeMounts,
-					},
-				}, {
-					Container: corev1.Container{
-						Name:       "build",
-						Image:      opt.Builder,
-						WorkingDir: workspaceDirectory,
-						Command:    []string{"/cnb/lifecycle/builder"},
-						Args: []string{
-							"-app=" + workspaceDirectory,
-							"-layers=/layers",
-							"-group=/layers/group.toml",
-							"-plan=/layers/plan.toml",
-						},
-						VolumeMounts: volumeMounts,
-					},
-				}, {
-					Container: corev1.Container{
-						Name:       "export",
-						Image:      opt.Builder,
-						WorkingDir: workspaceDirectory,
-						Command:    []string{"/cnb/lifecycle/exporter"},
-						Args: []string{
-							"-app=" + workspaceDirectory,
-							"-layers=/layers",
-							"-group=/layers/group.toml",
-							"-analyzed=/layers/analyze.toml",
-							"-cache-dir=/cache",
-							target.Name(),
-						},
-						Env: []corev1.EnvVar{{
-							Name:  "DOCKER_CONFIG",
-							Value: "/tekton/home/.docker",
-						}},
-						VolumeMounts: volumeMounts,
-					},
-				}, {
-					Container: corev1.Container{
-						Name:       "extract-digest",
-						Image:      ExtractDigestImage.String(),
-						WorkingDir: workspaceDirectory,
-						Args:       []string{"-output=/tekton/results/IMAGE-DIGEST"},
-					},
-				}},
+				Steps:   steps,
 			},
 		},
 	}
-}
-
-func determineUserAndGroup(builder string) (uid int64, gid int64) {
-	uid, gid = 1000, 1000
-	ref, err := name.ParseReference(builder)
-	if err != nil {
-		log.Print("Error parsing reference: ", err)
-		return
-	}
-	img, err := remote.Image(ref)
-	if err != nil {
-		log.Print("Error parsing reference: ", err)
-		return
-	}
-	cfg, err := img.ConfigFile()
-	if err != nil {
-		log.Print("Error reading config file: ", err)
-		return
-	}
-	parts := strings.SplitN(cfg.Config.User, ":", 2)
-	if len(parts) < 2 {
-		parts = append(parts, parts[0])
-	}
-	user, err := strconv.Atoi(parts[0])
-	if err != nil {
-		log.Print("Error parsing uid: ", parts[0])
-		return
-	}
-	uid = int64(user)
-	group, err := strconv.Atoi(parts[1])
-	if err != nil {
-		log.Print("Error parsing gid: ", parts[1])
-		return
+	if opt.DetectOnly {
+		tr.Spec.TaskSpec.Results = nil
+		tr.Annotations[builds.NoImageAnnotation] = "true"
 	}
-	gid = int64(group)
-	return
+	return tr, nil
 }
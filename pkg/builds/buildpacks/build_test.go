@@ -0,0 +1,265 @@
+package buildpacks_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/builds/buildpacks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// publishBuilder publishes a fake builder image with the provided config to a
+// test registry, returning its tag.
+func publishBuilder(t *testing.T, cfg v1.Config) name.Tag {
+	s := httptest.NewServer(registry.New())
+	t.Cleanup(s.Close)
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	tag, err := name.NewTag(u.Host+"/buildpacks/builder:latest", name.WeakValidation)
+	require.NoError(t, err)
+	img, err := random.Image(1024, 1)
+	require.NoError(t, err)
+	img, err = mutate.Config(img, cfg)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(tag, img))
+	return tag
+}
+
+func TestBuildBuilder(t *testing.T) {
+	builder := publishBuilder(t, v1.Config{
+		User: "cnb",
+		Env:  []string{"CNB_USER_ID=1001", "CNB_GROUP_ID=1002"},
+	})
+	target, err := name.NewTag("gcr.io/foo/bar:v1")
+	require.NoError(t, err)
+	sourceSteps := []tknv1beta1.Step{{Container: corev1.Container{Name: "extract-bundle"}}}
+
+	tr, err := buildpacks.Build(context.TODO(), sourceSteps, target, buildpacks.Options{
+		Builder: builder.String(),
+	})
+	require.NoError(t, err)
+	desc, err := remote.Head(builder)
+	require.NoError(t, err)
+	for _, step := range tr.Spec.TaskSpec.Steps {
+		switch step.Name {
+		case "detect", "analyze", "restore", "build", "export":
+			assert.Equal(t, builder.Context().Digest(desc.Digest.String()).String(), step.Image, "the builder is pinned")
+		case "extract-bundle":
+			assert.Equal(t, int64(1001), *step.SecurityContext.RunAsUser)
+			assert.Equal(t, int64(1002), *step.SecurityContext.RunAsGroup)
+		}
+	}
+
+	_, err = buildpacks.Build(context.TODO(), sourceSteps, target, buildpacks.Options{
+		Builder: publishBuilder(t, v1.Config{User: "cnb"}).String(),
+	})
+	assert.Error(t, err, "the user of the builder cannot be determined")
+
+	_, err = buildpacks.Build(context.TODO(), sourceSteps, target, buildpacks.Options{
+		Builder: builder.Context().Tag("missing").String(),
+	})
+	assert.Error(t, err, "the builder does not exist")
+}
+
+func TestBuildCache(t *testing.T) {
+	builder := publishBuilder(t, v1.Config{User: "1000:1000"})
+	target, err := name.NewTag("gcr.io/foo/bar:v1")
+	require.NoError(t, err)
+	sourceSteps := []tknv1beta1.Step{{Container: corev1.Container{Name: "extract-bundle"}}}
+
+	stepArgs := func(tr *tknv1beta1.TaskRun, name string) []string {
+		for _, step := range tr.Spec.TaskSpec.Steps {
+			if step.Name == name {
+				return step.Args
+			}
+		}
+		t.Fatalf("no step %s", name)
+		return nil
+	}
+
+	tr, err := buildpacks.Build(context.TODO(), sourceSteps, target, buildpacks.Options{
+		Builder:    builder.String(),
+		CacheImage: buildpacks.DefaultCacheImage,
+	})
+	require.NoError(t, err)
+	for _, step := range []string{"analyze", "restore", "export"} {
+		assert.Contains(t, stepArgs(tr, step), "-cache-image=gcr.io/foo/bar/cache:v1")
+	}
+
+	tr, err = buildpacks.Build(context.TODO(), sourceSteps, target, buildpacks.Options{
+		Builder:  builder.String(),
+		CachePVC: "buildpacks-cache",
+	})
+	require.NoError(t, err)
+	for _, step := range []string{"analyze", "restore", "export"} {
+		assert.Contains(t, stepArgs(tr, step), "-cache-dir=/cache")
+	}
+	var claims []string
+	for _, v := range tr.Spec.TaskSpec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			claims = append(claims, v.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	assert.Equal(t, []string{"buildpacks-cache"}, claims)
+
+	_, err = buildpacks.Build(context.TODO(), sourceSteps, target, buildpacks.Options{
+		Builder:    builder.String(),
+		CacheImage: buildpacks.DefaultCacheImage,
+		CachePVC:   "buildpacks-cache",
+	})
+	assert.Error(t, err, "only one of the caches may be used")
+}
+
+func TestBuildDetectOnly(t *testing.T) {
+	builder := publishBuilder(t, v1.Config{User: "1000:1000"})
+	target, err := name.NewTag("gcr.io/foo/bar:v1")
+	require.NoError(t, err)
+	sourceSteps := []tknv1beta1.Step{{Container: corev1.Container{Name: "extract-bundle"}}}
+
+	tr, err := buildpacks.Build(context.TODO(), sourceSteps, target, buildpacks.Options{
+		Builder:    builder.String(),
+		DetectOnly: true,
+	})
+	require.NoError(t, err)
+	var names []string
+	for _, step := range tr.Spec.TaskSpec.Steps {
+		names = append(names, step.Name)
+	}
+	assert.Equal(t, []string{"extract-bundle", "platform-setup", "detect", "print-plan"}, names)
+	assert.Empty(t, tr.Spec.TaskSpec.Results, "no image is exported")
+	assert.Contains(t, tr.Annotations, builds.NoImageAnnotation)
+}
+
+func TestBuildLifecycle(t *testing.T) {
+	target, err := name.NewTag("gcr.io/foo/bar:v1")
+	require.NoError(t, err)
+	sourceSteps := []tknv1beta1.Step{{Container: corev1.Container{Name: "extract-bundle"}}}
+
+	stepNames := func(tr *tknv1beta1.TaskRun) []string {
+		var names []string
+		for _, step := range tr.Spec.TaskSpec.Steps {
+			names = append(names, step.Name)
+		}
+		return names
+	}
+
+	// A builder with a non-root group owns the volumes through it.
+	tr, err := buildpacks.Build(context.TODO(), sourceSteps, target, buildpacks.Options{
+		Builder:      publishBuilder(t, v1.Config{User: "1000:1000"}).String(),
+		TrustBuilder: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"extract-bundle", "platform-setup", "create", "extract-digest"}, stepNames(tr))
+	assert.Equal(t, int64(1000), *tr.Spec.PodTemplate.SecurityContext.FSGroup)
+	create := tr.Spec.TaskSpec.Steps[2]
+	assert.Equal(t, []string{"/cnb/lifecycle/creator"}, create.Command)
+	assert.Contains(t, create.Args, target.Name())
+	assert.Contains(t, create.Env, corev1.EnvVar{Name: "CNB_PLATFORM_API", Value: buildpacks.PlatformAPIs[0]})
+
+	// One with a root group needs the volumes chowned, and negotiates the Platform API.
+	tr, err = buildpacks.Build(context.TODO(), sourceSteps, target, buildpacks.Options{
+		Builder: publishBuilder(t, v1.Config{
+			User: "1000:0",
+			Labels: map[string]string{
+				buildpacks.BuilderMetadataLabel: `{"lifecycle":{"apis":{"platform":{"deprecated":["0.2"],"supported":["0.3","0.4","0.5","0.6","0.7"]}}}}`,
+			},
+		}).String(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prepare", "extract-bundle", "platform-setup", "detect", "analyze", "restore", "build", "export", "extract-digest"}, stepNames(tr))
+	assert.Nil(t, tr.Spec.PodTemplate.SecurityContext)
+	assert.Contains(t, tr.Spec.TaskSpec.Steps[3].Env, corev1.EnvVar{Name: "CNB_PLATFORM_API", Value: "0.6"})
+
+	_, err = buildpacks.Build(context.TODO(), sourceSteps, target, buildpacks.Options{
+		Builder: publishBuilder(t, v1.Config{
+			User: "1000:1000",
+			Labels: map[string]string{
+				buildpacks.BuilderMetadataLabel: `{"lifecycle":{"api":{"platform":"0.2"}}}`,
+			},
+		}).String(),
+	})
+	assert.Error(t, err, "the builder only supports an older Platform API")
+
+	_, err = buildpacks.Build(context.TODO(), sourceSteps, target, buildpacks.Options{
+		Builder: publishBuilder(t, v1.Config{
+			User: "1000:1000",
+			Labels: map[string]string{
+				buildpacks.BuilderMetadataLabel: `{"lifecycle":{"apis":{"platform":{"supported":["0.2","0.3"]}}}}`,
+			},
+		}).String(),
+	})
+	assert.Error(t, err, "the builder's exporter writes no report.toml")
+}
+
+func TestBuildLocalPlatform(t *testing.T) {
+	builder := publishBuilder(t, v1.Config{User: "1000:1000"})
+	target, err := name.NewTag("gcr.io/foo/bar:v1")
+	require.NoError(t, err)
+	sourceSteps := []tknv1beta1.Step{{Container: corev1.Container{Name: "extract-bundle"}}}
+
+	for _, trust := range []bool{false, true} {
+		tr, err := buildpacks.Build(context.TODO(), sourceSteps, target, buildpacks.Options{
+			Builder:      builder.String(),
+			OverrideFile: "overrides.toml",
+			Env:          []corev1.EnvVar{{Name: "BP_JVM_VERSION", Value: "17"}},
+			TrustBuilder: trust,
+			AppDir:       builds.WorkspaceDir,
+		})
+		require.NoError(t, err)
+		tr.Annotations[builds.NoImageAnnotation] = "true"
+
+		// The phases running buildpacks see the env that platform-setup wrote.
+		seen := map[string]string{}
+		readEnv := func(ctx context.Context, step tknv1beta1.Step) error {
+			for _, arg := range step.Args {
+				if strings.HasPrefix(arg, "-platform=") {
+					data, err := ioutil.ReadFile(filepath.Join(strings.TrimPrefix(arg, "-platform="), "env", "BP_JVM_VERSION"))
+					if err != nil {
+						return err
+					}
+					seen[step.Name] = string(data)
+				}
+			}
+			return nil
+		}
+		steps := buildpacks.LocalSteps()
+		for _, name := range []string{"detect", "build", "create"} {
+			steps[name] = readEnv
+		}
+
+		dir, err := ioutil.TempDir("", "")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		le := &builds.LocalExecutor{
+			Workspace: dir,
+			Steps:     steps,
+			SkipSteps: sets.NewString("extract-bundle", "analyze", "restore", "export", "extract-digest"),
+		}
+		_, err = le.Execute(context.TODO(), target.String(), tr)
+		require.NoError(t, err)
+
+		if trust {
+			assert.Equal(t, map[string]string{"create": "17"}, seen)
+		} else {
+			assert.Equal(t, map[string]string{"detect": "17", "build": "17"}, seen)
+		}
+	}
+}
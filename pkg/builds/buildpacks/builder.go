@@ -0,0 +1,195 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildpacks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	errs "github.com/pkg/errors"
+)
+
+// builderMetadata is what we need to know about a builder image to run its lifecycle.
+type builderMetadata struct {
+	// image pins the builder image by digest.
+	image name.Digest
+
+	// uid and gid are the user and group as which the lifecycle runs.
+	uid, gid int64
+
+	// platformAPI is the Platform API with which we run the lifecycle.
+	platformAPI string
+}
+
+// BuilderMetadataLabel is the label in which builders describe themselves,
+// including the Platform APIs their lifecycle supports.
+const BuilderMetadataLabel = "io.buildpacks.builder.metadata"
+
+// PlatformAPIs are the versions of the Platform API with whose lifecycle
+// flags we drive builders, oldest first.  Before 0.4, the exporter writes no
+// report.toml from which to extract the digest, and from 0.7, the analyzer
+// runs before the detector and takes different flags, which we do not
+// support yet.
+var PlatformAPIs = []string{"0.4", "0.5", "0.6"}
+
+// builderLabel holds the subset of the BuilderMetadataLabel that we consume.
+type builderLabel struct {
+	Lifecycle struct {
+		// API is how older lifecycles describe the single Platform API they support.
+		API struct {
+			Platform string `json:"platform"`
+		} `json:"api"`
+		APIs struct {
+			Platform struct {
+				Deprecated []string `json:"deprecated"`
+				Supported  []string `json:"supported"`
+			} `json:"platform"`
+		} `json:"apis"`
+	} `json:"lifecycle"`
+}
+
+var (
+	buildersMu sync.Mutex
+	// builders caches the metadata of the builders we have resolved, by digest.
+	builders = make(map[string]*builderMetadata)
+)
+
+// resolveBuilder resolves the builder image (with the default keychain) to its
+// digest, and determines the metadata the lifecycle needs from its config.
+func resolveBuilder(ctx context.Context, builder string) (*builderMetadata, error) {
+	ref, err := name.ParseReference(builder, name.WeakValidation)
+	if err != nil {
+		return nil, errs.Wrapf(err, "invalid builder %q", builder)
+	}
+	opts := []remote.Option{
+		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+		remote.WithContext(ctx),
+	}
+	desc, err := remote.Get(ref, opts...)
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to resolve builder %s", builder)
+	}
+	image := ref.Context().Digest(desc.Digest.String())
+
+	buildersMu.Lock()
+	md, ok := builders[image.String()]
+	buildersMu.Unlock()
+	if ok {
+		return md, nil
+	}
+
+	// For multi-platform builders, this picks the linux/amd64 image, whose
+	// user should be the same as that of the others.
+	img, err := desc.Image()
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to fetch builder %s", builder)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to read the config of builder %s", builder)
+	}
+	uid, gid, err := userAndGroup(cfg)
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to determine the user and group of builder %s", builder)
+	}
+	platformAPI, err := platformAPI(cfg)
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to determine the Platform API of builder %s", builder)
+	}
+	md = &builderMetadata{
+		image:       image,
+		uid:         uid,
+		gid:         gid,
+		platformAPI: platformAPI,
+	}
+
+	buildersMu.Lock()
+	defer buildersMu.Unlock()
+	builders[image.String()] = md
+	return md, nil
+}
+
+// userAndGroup determines the user and group of the builder from the CNB_USER_ID
+// and CNB_GROUP_ID environment variables the platform spec requires, falling
+// back on a numeric uid[:gid] user.
+func userAndGroup(cfg *v1.ConfigFile) (uid int64, gid int64, err error) {
+	var user, group string
+	for _, ev := range cfg.Config.Env {
+		parts := strings.SplitN(ev, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "CNB_USER_ID":
+			user = parts[1]
+		case "CNB_GROUP_ID":
+			group = parts[1]
+		}
+	}
+	if user == "" {
+		parts := strings.SplitN(cfg.Config.User, ":", 2)
+		user = parts[0]
+		if len(parts) == 2 {
+			group = parts[1]
+		}
+	}
+	if group == "" {
+		group = user
+	}
+
+	if uid, err = strconv.ParseInt(user, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("neither CNB_USER_ID nor a numeric user is set, got user: %q", cfg.Config.User)
+	}
+	if gid, err = strconv.ParseInt(group, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid group: %q", group)
+	}
+	return uid, gid, nil
+}
+
+// platformAPI returns the newest of our PlatformAPIs that the builder's lifecycle
+// supports, per its BuilderMetadataLabel.  Builders without the label are assumed
+// to support the oldest of them.
+func platformAPI(cfg *v1.ConfigFile) (string, error) {
+	data, ok := cfg.Config.Labels[BuilderMetadataLabel]
+	if !ok {
+		return PlatformAPIs[0], nil
+	}
+	var bl builderLabel
+	if err := json.Unmarshal([]byte(data), &bl); err != nil {
+		return "", errs.Wrapf(err, "failed to parse label %s", BuilderMetadataLabel)
+	}
+	supported := append(bl.Lifecycle.APIs.Platform.Supported, bl.Lifecycle.APIs.Platform.Deprecated...)
+	if bl.Lifecycle.API.Platform != "" {
+		supported = append(supported, bl.Lifecycle.API.Platform)
+	}
+	for i := len(PlatformAPIs) - 1; i >= 0; i-- {
+		for _, api := range supported {
+			if api == PlatformAPIs[i] {
+				return api, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("the lifecycle supports Platform APIs %v, but we support %v", supported, PlatformAPIs)
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildpacks
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/pelletier/go-toml"
+	errs "github.com/pkg/errors"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+// LocalSteps returns in-process implementations of the steps of the TaskRun
+// produced by Build whose images are not available when running the lifecycle
+// locally, from within the builder image.
+func LocalSteps() map[string]builds.LocalStep {
+	return map[string]builds.LocalStep{
+		// We already run as the builder's user, so there is nothing to chown.
+		prepareStepName:       func(context.Context, tknv1beta1.Step) error { return nil },
+		platformSetupStepName: platformSetup,
+		extractDigestStepName: extractDigest,
+	}
+}
+
+// projectDescriptor holds the subset of project.toml that we consume.
+type projectDescriptor struct {
+	Build struct {
+		Env []struct {
+			Name  string `toml:"name"`
+			Value string `toml:"value"`
+		} `toml:"env"`
+	} `toml:"build"`
+}
+
+// platformSetup populates the platform directory's env/ from the overrides file
+// and --env flags passed to the platform-setup step.
+func platformSetup(ctx context.Context, step tknv1beta1.Step) error {
+	platformDir, err := mountPath(step, platformVolumeName)
+	if err != nil {
+		return err
+	}
+
+	env := make(map[string]string, len(step.Args)/2)
+	for i := 0; i+1 < len(step.Args); i += 2 {
+		switch flag, value := step.Args[i], step.Args[i+1]; flag {
+		case "--overrides":
+			data, err := ioutil.ReadFile(value)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return errs.Wrapf(err, "failed to read %s", value)
+			}
+			var pd projectDescriptor
+			if err := toml.Unmarshal(data, &pd); err != nil {
+				return errs.Wrapf(err, "failed to parse %s", value)
+			}
+			for _, ev := range pd.Build.Env {
+				env[ev.Name] = ev.Value
+			}
+		case "--env":
+			parts := strings.SplitN(value, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("expected KEY=VALUE for --env, got: %s", value)
+			}
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	envDir := filepath.Join(platformDir, "env")
+	if err := os.MkdirAll(envDir, 0755); err != nil {
+		return errs.Wrapf(err, "failed to create dir %s", envDir)
+	}
+	for k, v := range env {
+		if err := ioutil.WriteFile(filepath.Join(envDir, k), []byte(v), 0644); err != nil {
+			return errs.Wrapf(err, "failed to write env %s", k)
+		}
+	}
+	return nil
+}
+
+// reportDescriptor holds the subset of the exporter's report.toml that we consume.
+type reportDescriptor struct {
+	Image struct {
+		Digest string `toml:"digest"`
+	} `toml:"image"`
+}
+
+// extractDigest writes the digest of the exported image from the exporter's
+// report.toml to the file passed via -output.
+func extractDigest(ctx context.Context, step tknv1beta1.Step) error {
+	layersDir, err := mountPath(step, layersVolumeName)
+	if err != nil {
+		return err
+	}
+
+	var output string
+	for _, arg := range step.Args {
+		if strings.HasPrefix(arg, "-output=") {
+			output = strings.TrimPrefix(arg, "-output=")
+		}
+	}
+	if output == "" {
+		return fmt.Errorf("missing -output flag for step %s", step.Name)
+	}
+
+	reportFile := filepath.Join(layersDir, "report.toml")
+	data, err := ioutil.ReadFile(reportFile)
+	if err != nil {
+		return errs.Wrapf(err, "failed to read %s", reportFile)
+	}
+	var rd reportDescriptor
+	if err := toml.Unmarshal(data, &rd); err != nil {
+		return errs.Wrapf(err, "failed to parse %s", reportFile)
+	}
+	if rd.Image.Digest == "" {
+		return fmt.Errorf("no image digest found in %s", reportFile)
+	}
+	return ioutil.WriteFile(output, []byte(rd.Image.Digest), 0644)
+}
+
+func mountPath(step tknv1beta1.Step, volumeName string) (string, error) {
+	for _, vm := range step.VolumeMounts {
+		if vm.Name == volumeName {
+			return vm.MountPath, nil
+		}
+	}
+	return "", fmt.Errorf("step %s does not mount %s", step.Name, volumeName)
+}
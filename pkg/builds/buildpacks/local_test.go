@@ -0,0 +1,179 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildpacks
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// readEnvDir returns the contents of the files in the env directory.
+func readEnvDir(t *testing.T, dir string) map[string]string {
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	env := make(map[string]string, len(infos))
+	for _, info := range infos {
+		data, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		require.NoError(t, err)
+		env[info.Name()] = string(data)
+	}
+	return env
+}
+
+func TestPlatformSetup(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	overrides := filepath.Join(tmpDir, "overrides.toml")
+	require.NoError(t, ioutil.WriteFile(overrides, []byte(`
+[[build.env]]
+name = "BP_JVM_VERSION"
+value = "11"
+
+[[build.env]]
+name = "FROM_OVERRIDES"
+value = "yes"
+`), 0644))
+	platformDir := filepath.Join(tmpDir, "platform")
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    map[string]string
+		wantErr string
+	}{{
+		name: "missing overrides",
+		args: []string{"--overrides", filepath.Join(tmpDir, "missing.toml")},
+		want: map[string]string{},
+	}, {
+		name: "overrides",
+		args: []string{"--overrides", overrides},
+		want: map[string]string{"BP_JVM_VERSION": "11", "FROM_OVERRIDES": "yes"},
+	}, {
+		name: "env takes precedence",
+		args: []string{
+			"--overrides", overrides,
+			"--env", "BP_JVM_VERSION=17",
+			"--env", "EMPTY=",
+			"--env", "WITH_EQUALS=a=b",
+		},
+		want: map[string]string{
+			"BP_JVM_VERSION": "17",
+			"FROM_OVERRIDES": "yes",
+			"EMPTY":          "",
+			"WITH_EQUALS":    "a=b",
+		},
+	}, {
+		name:    "invalid env",
+		args:    []string{"--env", "FOO"},
+		wantErr: "expected KEY=VALUE for --env, got: FOO",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, os.RemoveAll(platformDir))
+			step := tknv1beta1.Step{
+				Container: corev1.Container{
+					Name: platformSetupStepName,
+					Args: test.args,
+					VolumeMounts: []corev1.VolumeMount{{
+						Name:      platformVolumeName,
+						MountPath: platformDir,
+					}},
+				},
+			}
+			err := LocalSteps()[platformSetupStepName](context.TODO(), step)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, readEnvDir(t, filepath.Join(platformDir, "env")))
+		})
+	}
+}
+
+func TestExtractDigest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	layersDir := filepath.Join(tmpDir, "layers")
+	require.NoError(t, os.MkdirAll(layersDir, 0755))
+	output := filepath.Join(tmpDir, "IMAGE-DIGEST")
+
+	tests := []struct {
+		name    string
+		report  string
+		want    string
+		wantErr string
+	}{{
+		name:   "digest",
+		report: "[image]\ntags = [\"gcr.io/foo/bar:latest\"]\ndigest = \"sha256:deadbeef\"\n",
+		want:   "sha256:deadbeef",
+	}, {
+		name:    "missing digest",
+		report:  "[image]\ntags = [\"gcr.io/foo/bar:latest\"]\n",
+		wantErr: "no image digest found",
+	}, {
+		name:    "missing report",
+		wantErr: "failed to read",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reportFile := filepath.Join(layersDir, "report.toml")
+			require.NoError(t, os.RemoveAll(reportFile))
+			require.NoError(t, os.RemoveAll(output))
+			if test.report != "" {
+				require.NoError(t, ioutil.WriteFile(reportFile, []byte(test.report), 0644))
+			}
+
+			step := tknv1beta1.Step{
+				Container: corev1.Container{
+					Name: extractDigestStepName,
+					Args: []string{"-output=" + output},
+					VolumeMounts: []corev1.VolumeMount{{
+						Name:      layersVolumeName,
+						MountPath: layersDir,
+					}},
+				},
+			}
+			err := LocalSteps()[extractDigestStepName](context.TODO(), step)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				assert.NoFileExists(t, output)
+				return
+			}
+			require.NoError(t, err)
+			data, err := ioutil.ReadFile(output)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, string(data))
+		})
+	}
+}
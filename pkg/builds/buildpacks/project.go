@@ -0,0 +1,71 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildpacks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pelletier/go-toml"
+	errs "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ProjectFile is the name of the project descriptor, whose build
+// environment (e.g. BP_* settings) we pass to the buildpacks.
+const ProjectFile = "project.toml"
+
+// ProjectEnv returns the build environment configured by the project.toml and the
+// overrides file in dir, where the overrides take precedence.  Missing files are
+// treated as empty.
+func ProjectEnv(dir, overrideFile string) (map[string]string, error) {
+	env := make(map[string]string)
+	for _, file := range []string{ProjectFile, overrideFile} {
+		path := filepath.Join(dir, file)
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, errs.Wrapf(err, "failed to read %s", path)
+		}
+		var pd projectDescriptor
+		if err := toml.Unmarshal(data, &pd); err != nil {
+			return nil, errs.Wrapf(err, "failed to parse %s", path)
+		}
+		for _, ev := range pd.Build.Env {
+			env[ev.Name] = ev.Value
+		}
+	}
+	return env, nil
+}
+
+// EnvVars turns the environment into a list of variables, ordered by name.
+func EnvVars(env map[string]string) []corev1.EnvVar {
+	if len(env) == 0 {
+		return nil
+	}
+	evs := make([]corev1.EnvVar, 0, len(env))
+	for k, v := range env {
+		evs = append(evs, corev1.EnvVar{Name: k, Value: v})
+	}
+	sort.Slice(evs, func(i, j int) bool {
+		return evs[i].Name < evs[j].Name
+	})
+	return evs
+}
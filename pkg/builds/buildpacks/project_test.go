@@ -0,0 +1,45 @@
+package buildpacks_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattmoor/mink/pkg/builds/buildpacks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestProjectEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "project-env")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, buildpacks.ProjectFile), []byte(`
+[[build.env]]
+name = "BP_JVM_VERSION"
+value = "11"
+
+[[build.env]]
+name = "BP_MAVEN_BUILD_ARGUMENTS"
+value = "package"
+`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "overrides.toml"), []byte(`
+[[build.env]]
+name = "BP_JVM_VERSION"
+value = "15"
+`), 0644))
+
+	env, err := buildpacks.ProjectEnv(dir, "overrides.toml")
+	require.NoError(t, err)
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "BP_JVM_VERSION", Value: "15"},
+		{Name: "BP_MAVEN_BUILD_ARGUMENTS", Value: "package"},
+	}, buildpacks.EnvVars(env))
+
+	env, err = buildpacks.ProjectEnv(filepath.Join(dir, "missing"), "overrides.toml")
+	require.NoError(t, err)
+	assert.Empty(t, env)
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildpacks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	errs "github.com/pkg/errors"
+)
+
+// MetadataLabel is the label in which the lifecycle records the metadata of the
+// images it exports, including the run image on which they are based.
+const MetadataLabel = "io.buildpacks.lifecycle.metadata"
+
+// lifecycleMetadata holds the subset of the lifecycle metadata that we consume.
+type lifecycleMetadata struct {
+	RunImage struct {
+		// TopLayer is the diff ID of the top layer of the run image.
+		TopLayer string `json:"topLayer"`
+		// Reference is the run image's digest.
+		Reference string `json:"reference"`
+	} `json:"runImage"`
+	Stack struct {
+		RunImage struct {
+			Image string `json:"image"`
+		} `json:"runImage"`
+	} `json:"stack"`
+}
+
+// Rebase swaps the run image layers of the buildpack-built image for those of the
+// run image (which defaults to the stack's run image recorded in the image), and
+// publishes the result to the image's tag (or by digest), returning its digest.
+func Rebase(ctx context.Context, image name.Reference, runImage string) (name.Digest, error) {
+	opts := []remote.Option{
+		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+		remote.WithContext(ctx),
+	}
+	orig, err := remote.Image(image, opts...)
+	if err != nil {
+		return name.Digest{}, errs.Wrapf(err, "failed to fetch %s", image)
+	}
+	cfg, err := orig.ConfigFile()
+	if err != nil {
+		return name.Digest{}, errs.Wrapf(err, "failed to read the config of %s", image)
+	}
+	label, ok := cfg.Config.Labels[MetadataLabel]
+	if !ok {
+		return name.Digest{}, fmt.Errorf("%s was not built with buildpacks: missing the %s label", image, MetadataLabel)
+	}
+	var md lifecycleMetadata
+	if err := json.Unmarshal([]byte(label), &md); err != nil {
+		return name.Digest{}, errs.Wrapf(err, "failed to parse the %s label of %s", MetadataLabel, image)
+	}
+
+	if runImage == "" {
+		runImage = md.Stack.RunImage.Image
+	}
+	if runImage == "" {
+		return name.Digest{}, fmt.Errorf("%s does not record its stack's run image, so one must be provided", image)
+	}
+	runRef, err := name.ParseReference(runImage, name.WeakValidation)
+	if err != nil {
+		return name.Digest{}, errs.Wrapf(err, "invalid run image %q", runImage)
+	}
+	newBase, err := remote.Image(runRef, opts...)
+	if err != nil {
+		return name.Digest{}, errs.Wrapf(err, "failed to fetch run image %s", runRef)
+	}
+
+	// The old run image may no longer exist, so rather than fetch it we take its
+	// layers from the image, up to the top layer recorded in the metadata.
+	layers, err := orig.Layers()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	top := -1
+	for i, l := range layers {
+		diffID, err := l.DiffID()
+		if err != nil {
+			return name.Digest{}, err
+		}
+		if diffID.String() == md.RunImage.TopLayer {
+			top = i
+			break
+		}
+	}
+	if top < 0 {
+		return name.Digest{}, fmt.Errorf("%s does not contain the top layer of its run image: %q", image, md.RunImage.TopLayer)
+	}
+	oldBase, err := mutate.AppendLayers(empty.Image, layers[:top+1]...)
+	if err != nil {
+		return name.Digest{}, err
+	}
+
+	// Rebase relies on the history to tell the layers apart, which the
+	// lifecycle may not record, so we make sure it has an entry per layer.
+	if orig, err = withHistory(orig); err != nil {
+		return name.Digest{}, err
+	}
+	if newBase, err = withHistory(newBase); err != nil {
+		return name.Digest{}, err
+	}
+	rebased, err := mutate.Rebase(orig, oldBase, newBase)
+	if err != nil {
+		return name.Digest{}, errs.Wrapf(err, "failed to rebase %s onto %s", image, runRef)
+	}
+
+	// Record the new run image in the metadata.
+	if rebased, err = withRunImage(rebased, label, newBase, runRef); err != nil {
+		return name.Digest{}, err
+	}
+
+	h, err := rebased.Digest()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	digest := image.Context().Digest(h.String())
+	var target name.Reference = digest
+	if tag, ok := image.(name.Tag); ok {
+		target = tag
+	}
+	if err := remote.Write(target, rebased, opts...); err != nil {
+		return name.Digest{}, errs.Wrapf(err, "failed to publish %s", target)
+	}
+	return digest, nil
+}
+
+// withHistory returns the image with an (empty) history entry for each of its
+// layers, unless its history already accounts for them.
+func withHistory(img v1.Image) (v1.Image, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	nonEmpty := 0
+	for _, h := range cfg.History {
+		if !h.EmptyLayer {
+			nonEmpty++
+		}
+	}
+	if nonEmpty == len(layers) {
+		return img, nil
+	}
+	cfg = cfg.DeepCopy()
+	cfg.History = make([]v1.History, len(layers))
+	return mutate.ConfigFile(img, cfg)
+}
+
+// withRunImage returns the image with the lifecycle metadata label updated to
+// record the run image (referenced by runRef) on which it is now based.
+func withRunImage(img v1.Image, label string, runImage v1.Image, runRef name.Reference) (v1.Image, error) {
+	layers, err := runImage.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("run image %s has no layers", runRef)
+	}
+	topLayer, err := layers[len(layers)-1].DiffID()
+	if err != nil {
+		return nil, err
+	}
+	digest, err := runImage.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	// Preserve the parts of the metadata we don't consume.
+	var md map[string]interface{}
+	if err := json.Unmarshal([]byte(label), &md); err != nil {
+		return nil, err
+	}
+	md["runImage"] = map[string]string{
+		"topLayer":  topLayer.String(),
+		"reference": runRef.Context().Digest(digest.String()).String(),
+	}
+	b, err := json.Marshal(md)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg = cfg.DeepCopy()
+	cfg.Config.Labels[MetadataLabel] = string(b)
+	return mutate.ConfigFile(img, cfg)
+}
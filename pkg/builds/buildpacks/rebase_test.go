@@ -0,0 +1,104 @@
+package buildpacks_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/mattmoor/mink/pkg/builds/buildpacks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func diffIDs(t *testing.T, img v1.Image) []v1.Hash {
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	hs := make([]v1.Hash, 0, len(layers))
+	for _, l := range layers {
+		h, err := l.DiffID()
+		require.NoError(t, err)
+		hs = append(hs, h)
+	}
+	return hs
+}
+
+func TestRebase(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	// Publish the old and new run images, and an app image based on the old one.
+	oldRun, err := random.Image(1024, 2)
+	require.NoError(t, err)
+	newRun, err := random.Image(1024, 3)
+	require.NoError(t, err)
+	newRunTag, err := name.NewTag(u.Host+"/stack/run:latest", name.WeakValidation)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(newRunTag, newRun))
+
+	app, err := random.Image(1024, 2)
+	require.NoError(t, err)
+	appLayers, err := app.Layers()
+	require.NoError(t, err)
+	orig, err := mutate.AppendLayers(oldRun, appLayers...)
+	require.NoError(t, err)
+	oldDiffIDs := diffIDs(t, oldRun)
+	label, err := json.Marshal(map[string]interface{}{
+		"runImage": map[string]string{
+			"topLayer":  oldDiffIDs[len(oldDiffIDs)-1].String(),
+			"reference": "sha256:deadbeef",
+		},
+		"stack": map[string]interface{}{
+			"runImage": map[string]string{
+				"image": newRunTag.String(),
+			},
+		},
+		"buildpacks": []string{"kept"},
+	})
+	require.NoError(t, err)
+	cfg, err := orig.ConfigFile()
+	require.NoError(t, err)
+	cfg = cfg.DeepCopy()
+	cfg.Config.Labels = map[string]string{buildpacks.MetadataLabel: string(label)}
+	orig, err = mutate.ConfigFile(orig, cfg)
+	require.NoError(t, err)
+
+	appTag, err := name.NewTag(u.Host+"/apps/app:latest", name.WeakValidation)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(appTag, orig))
+
+	digest, err := buildpacks.Rebase(context.TODO(), appTag, "")
+	require.NoError(t, err)
+
+	rebased, err := remote.Image(appTag)
+	require.NoError(t, err)
+	h, err := rebased.Digest()
+	require.NoError(t, err)
+	assert.Equal(t, h.String(), digest.DigestStr(), "the tag is updated")
+	assert.Equal(t, append(diffIDs(t, newRun), diffIDs(t, app)...), diffIDs(t, rebased))
+
+	cfg, err = rebased.ConfigFile()
+	require.NoError(t, err)
+	var md map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(cfg.Config.Labels[buildpacks.MetadataLabel]), &md))
+	newDiffIDs := diffIDs(t, newRun)
+	newDigest, err := newRun.Digest()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"topLayer":  newDiffIDs[len(newDiffIDs)-1].String(),
+		"reference": newRunTag.Context().Digest(newDigest.String()).String(),
+	}, md["runImage"])
+	assert.Equal(t, []interface{}{"kept"}, md["buildpacks"])
+
+	_, err = buildpacks.Rebase(context.TODO(), newRunTag, "")
+	assert.Error(t, err, "the run image was not built with buildpacks")
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	tektonclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CancelGracePeriod is how long we wait for the pods of cancelled builds to terminate
+// before cleaning up after them.
+var CancelGracePeriod = 30 * time.Second
+
+// cancelTaskRun marks the named TaskRun as cancelled, and waits (briefly) for Tekton
+// to terminate its pod.  It is called once the context of the build has been cancelled,
+// so it uses its own context.
+func cancelTaskRun(client tektonclientset.Interface, kubeclient kubernetes.Interface, namespace, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), CancelGracePeriod)
+	defer cancel()
+
+	patch := []byte(fmt.Sprintf(`{"spec":{"status":%q}}`, tknv1beta1.TaskRunSpecStatusCancelled))
+	if _, err := client.TektonV1beta1().TaskRuns(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		log.Printf("WARNING: failed to cancel TaskRun %q: %v", name, err)
+		return
+	}
+	log.Printf("cancelled TaskRun %s, waiting for its pod to terminate", name)
+
+	// Tekton marks the TaskRun as done once it has deleted the pod, after which
+	// we wait for the pod to actually go away.
+	var podName string
+	err := wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		tr, err := client.TektonV1beta1().TaskRuns(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		podName = tr.Status.PodName
+		return tr.IsDone(), nil
+	}, ctx.Done())
+	if err == nil && podName != "" {
+		err = wait.PollImmediateUntil(time.Second, func() (bool, error) {
+			_, err := kubeclient.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+			if apierrs.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}, ctx.Done())
+	}
+	if err != nil {
+		log.Printf("WARNING: TaskRun %q did not terminate: %v", name, err)
+	}
+}
+
+// cancelPipelineRun marks the named PipelineRun as cancelled, and waits (briefly)
+// for Tekton to terminate its TaskRuns.  It is called once the context of the build
+// has been cancelled, so it uses its own context.
+func cancelPipelineRun(client tektonclientset.Interface, namespace, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), CancelGracePeriod)
+	defer cancel()
+
+	patch := []byte(fmt.Sprintf(`{"spec":{"status":%q}}`, tknv1beta1.PipelineRunSpecStatusCancelled))
+	if _, err := client.TektonV1beta1().PipelineRuns(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		log.Printf("WARNING: failed to cancel PipelineRun %q: %v", name, err)
+		return
+	}
+	log.Printf("cancelled PipelineRun %s, waiting for its TaskRuns to terminate", name)
+
+	err := wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		pr, err := client.TektonV1beta1().PipelineRuns(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return pr.IsDone(), nil
+	}, ctx.Done())
+	if err != nil {
+		log.Printf("WARNING: PipelineRun %q did not terminate: %v", name, err)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	tektonfake "github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"knative.dev/pkg/apis"
+)
+
+// captureLog returns the buffer to which the standard logger writes until the test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+// withGracePeriod overrides CancelGracePeriod until the test ends.
+func withGracePeriod(t *testing.T, d time.Duration) {
+	old := CancelGracePeriod
+	CancelGracePeriod = d
+	t.Cleanup(func() { CancelGracePeriod = old })
+}
+
+var cancelled = &apis.Condition{
+	Type:   apis.ConditionSucceeded,
+	Status: corev1.ConditionFalse,
+	Reason: "Cancelled",
+}
+
+func TestCancelTaskRun(t *testing.T) {
+	logs := captureLog(t)
+	withGracePeriod(t, 10*time.Second)
+
+	meta := metav1.ObjectMeta{Name: "build", Namespace: "default"}
+	client := tektonfake.NewSimpleClientset(&tknv1beta1.TaskRun{ObjectMeta: meta})
+	kubeclient := fake.NewSimpleClientset(&corev1.Pod{ObjectMeta: meta})
+
+	// Play the part of Tekton: once the TaskRun is cancelled, mark it done and
+	// delete its pod.
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			tr, err := client.TektonV1beta1().TaskRuns("default").Get(context.TODO(), "build", metav1.GetOptions{})
+			if err != nil || tr.Spec.Status != tknv1beta1.TaskRunSpecStatusCancelled {
+				return false, err
+			}
+			tr.Status.PodName = "build"
+			tr.Status.SetCondition(cancelled)
+			if _, err := client.TektonV1beta1().TaskRuns("default").UpdateStatus(context.TODO(), tr, metav1.UpdateOptions{}); err != nil {
+				return false, err
+			}
+			return true, kubeclient.CoreV1().Pods("default").Delete(context.TODO(), "build", metav1.DeleteOptions{})
+		})
+	}()
+
+	cancelTaskRun(client, kubeclient, "default", "build")
+	require.NoError(t, <-errCh)
+
+	pods, err := kubeclient.CoreV1().Pods("default").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, pods.Items, "we return once the pod is gone")
+	assert.Contains(t, logs.String(), "cancelled TaskRun build")
+	assert.NotContains(t, logs.String(), "WARNING")
+}
+
+func TestCancelTaskRunTimeout(t *testing.T) {
+	logs := captureLog(t)
+	withGracePeriod(t, 100*time.Millisecond)
+
+	meta := metav1.ObjectMeta{Name: "build", Namespace: "default"}
+	client := tektonfake.NewSimpleClientset(&tknv1beta1.TaskRun{ObjectMeta: meta})
+	kubeclient := fake.NewSimpleClientset(&corev1.Pod{ObjectMeta: meta})
+
+	// Nothing terminates the TaskRun, so we give up after the grace period.
+	start := time.Now()
+	cancelTaskRun(client, kubeclient, "default", "build")
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+
+	tr, err := client.TektonV1beta1().TaskRuns("default").Get(context.TODO(), "build", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, tknv1beta1.TaskRunSpecStatus(tknv1beta1.TaskRunSpecStatusCancelled), tr.Spec.Status)
+	assert.Contains(t, logs.String(), `WARNING: TaskRun "build" did not terminate`)
+}
+
+func TestCancelTaskRunNotFound(t *testing.T) {
+	logs := captureLog(t)
+
+	cancelTaskRun(tektonfake.NewSimpleClientset(), fake.NewSimpleClientset(), "default", "build")
+	assert.Contains(t, logs.String(), `WARNING: failed to cancel TaskRun "build"`)
+}
+
+func TestCancelPipelineRun(t *testing.T) {
+	logs := captureLog(t)
+	withGracePeriod(t, 10*time.Second)
+
+	meta := metav1.ObjectMeta{Name: "build", Namespace: "default"}
+	client := tektonfake.NewSimpleClientset(&tknv1beta1.PipelineRun{ObjectMeta: meta})
+
+	// Play the part of Tekton: once the PipelineRun is cancelled, mark it done.
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			pr, err := client.TektonV1beta1().PipelineRuns("default").Get(context.TODO(), "build", metav1.GetOptions{})
+			if err != nil || pr.Spec.Status != tknv1beta1.PipelineRunSpecStatusCancelled {
+				return false, err
+			}
+			pr.Status.SetCondition(cancelled)
+			_, err = client.TektonV1beta1().PipelineRuns("default").UpdateStatus(context.TODO(), pr, metav1.UpdateOptions{})
+			return err == nil, err
+		})
+	}()
+
+	cancelPipelineRun(client, "default", "build")
+	require.NoError(t, <-errCh)
+
+	pr, err := client.TektonV1beta1().PipelineRuns("default").Get(context.TODO(), "build", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, pr.IsDone())
+	assert.Contains(t, logs.String(), "cancelled PipelineRun build")
+	assert.NotContains(t, logs.String(), "WARNING")
+}
+
+func TestCancelPipelineRunTimeout(t *testing.T) {
+	logs := captureLog(t)
+	withGracePeriod(t, 100*time.Millisecond)
+
+	meta := metav1.ObjectMeta{Name: "build", Namespace: "default"}
+	client := tektonfake.NewSimpleClientset(&tknv1beta1.PipelineRun{ObjectMeta: meta})
+
+	start := time.Now()
+	cancelPipelineRun(client, "default", "build")
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+
+	pr, err := client.TektonV1beta1().PipelineRuns("default").Get(context.TODO(), "build", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, tknv1beta1.PipelineRunSpecStatus(tknv1beta1.PipelineRunSpecStatusCancelled), pr.Spec.Status)
+	assert.Contains(t, logs.String(), `WARNING: PipelineRun "build" did not terminate`)
+}
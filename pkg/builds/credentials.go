@@ -0,0 +1,96 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// CredentialsLabel is the label on the Secrets and ServiceAccounts created to
+	// run builds with the local credentials (--as=me).
+	CredentialsLabel = "mink.dev/credentials"
+
+	// TTLAnnotation holds how long after its creation (as a Go duration) an object
+	// created in support of a build may be garbage collected, should it outlive the
+	// build without being adopted by it.
+	TTLAnnotation = "mink.dev/ttl"
+
+	// SecretAnnotation and ServiceAccountAnnotation are the annotations through which
+	// a TaskRun notes the credentials created for it, so that it may adopt them.
+	SecretAnnotation         = "mink.dev/credentials-secret"
+	ServiceAccountAnnotation = "mink.dev/credentials-service-account"
+)
+
+// CredentialsTTL is the TTL with which we annotate the credentials we create.
+var CredentialsTTL = time.Hour
+
+// credentialsMeta returns the metadata for the credentials created for the TaskRun,
+// which carries the TaskRun's labels and annotations.
+func credentialsMeta(tr *tknv1beta1.TaskRun) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{
+		GenerateName: tr.GenerateName,
+		Namespace:    tr.Namespace,
+		Labels:       make(map[string]string, len(tr.Labels)+2),
+		Annotations:  make(map[string]string, len(tr.Annotations)+1),
+	}
+	for k, v := range tr.Labels {
+		meta.Labels[k] = v
+	}
+	for k, v := range tr.Annotations {
+		meta.Annotations[k] = v
+	}
+	meta.Labels[ManagedByLabel] = ManagedBy
+	meta.Labels[CredentialsLabel] = "true"
+	meta.Annotations[TTLAnnotation] = CredentialsTTL.String()
+	return meta
+}
+
+// adoptCredentials makes the owner (a TaskRun or PipelineRun) the owner of the credentials
+// noted in its annotations, so that Kubernetes garbage collects them along with it, even if
+// we are unable to clean them up ourselves.  Failing to do so isn't fatal to the build, as
+// the credentials are still subject to their TTL.
+func adoptCredentials(ctx context.Context, client kubernetes.Interface, namespace string, annotations map[string]string, owner metav1.OwnerReference) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"ownerReferences": []metav1.OwnerReference{owner},
+		},
+	})
+	if err != nil {
+		log.Printf("WARNING: failed to adopt credentials: %v", err)
+		return
+	}
+
+	if name, ok := annotations[SecretAnnotation]; ok {
+		if _, err := client.CoreV1().Secrets(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			log.Printf("WARNING: failed to make %s %q the owner of Secret %q: %v", owner.Kind, owner.Name, name, err)
+		}
+	}
+	if name, ok := annotations[ServiceAccountAnnotation]; ok {
+		if _, err := client.CoreV1().ServiceAccounts(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			log.Printf("WARNING: failed to make %s %q the owner of ServiceAccount %q: %v", owner.Kind, owner.Name, name, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package custom
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/mattmoor/mink/pkg/builds"
+	errs "github.com/pkg/errors"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ContextParam is the parameter through which Tasks receive the path of the
+	// reference within the source (which is in /workspace), if they declare it.
+	ContextParam = "CONTEXT"
+
+	// The annotations with which Tekton bundles describe the resource in each layer.
+	bundleKindAnnotation = "dev.tekton.image.kind"
+	bundleNameAnnotation = "dev.tekton.image.name"
+)
+
+// Options holds configuration options specific to builds by custom Tasks.
+type Options struct {
+	// Builder is the scheme of the references that the Task builds (e.g. jib).
+	Builder string
+
+	// Task is the spec of the Task that builds the references.  It must declare
+	// the builds.ImageParam parameter, through which it receives the image to
+	// publish, and the IMAGE-DIGEST result, to which it writes its digest.
+	Task *tknv1beta1.TaskSpec
+
+	// Path is the path of the reference within the source.
+	Path string
+
+	// Params holds the values of the other parameters of the Task.
+	Params map[string]string
+}
+
+// LoadFile loads the spec of the Task in the YAML file.
+func LoadFile(file string) (*tknv1beta1.TaskSpec, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to read %s", file)
+	}
+	return parseTask(data, file)
+}
+
+// LoadBundle loads the spec of the named Task from the Tekton bundle image,
+// fetched with the local registry credentials.
+func LoadBundle(ctx context.Context, bundle, task string) (*tknv1beta1.TaskSpec, error) {
+	ref, err := name.ParseReference(bundle, name.WeakValidation)
+	if err != nil {
+		return nil, errs.Wrapf(err, "invalid bundle %q", bundle)
+	}
+	img, err := remote.Image(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain), remote.WithContext(ctx))
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to fetch bundle %s", bundle)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to read the manifest of bundle %s", bundle)
+	}
+	for _, desc := range manifest.Layers {
+		if !strings.EqualFold(desc.Annotations[bundleKindAnnotation], "task") || desc.Annotations[bundleNameAnnotation] != task {
+			continue
+		}
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return nil, errs.Wrapf(err, "failed to fetch Task %s of bundle %s", task, bundle)
+		}
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return nil, errs.Wrapf(err, "failed to fetch Task %s of bundle %s", task, bundle)
+		}
+		defer rc.Close()
+
+		// The layer holds a single file, with the Task.
+		tr := tar.NewReader(rc)
+		if _, err := tr.Next(); err != nil {
+			return nil, errs.Wrapf(err, "failed to read Task %s of bundle %s", task, bundle)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, errs.Wrapf(err, "failed to read Task %s of bundle %s", task, bundle)
+		}
+		return parseTask(data, bundle)
+	}
+	return nil, fmt.Errorf("bundle %s has no Task %s", bundle, task)
+}
+
+// parseTask parses the Task (in YAML or JSON) from the source.
+func parseTask(data []byte, source string) (*tknv1beta1.TaskSpec, error) {
+	var task tknv1beta1.Task
+	if err := yaml.Unmarshal(data, &task); err != nil {
+		return nil, errs.Wrapf(err, "failed to parse the Task in %s", source)
+	}
+	if task.Kind != "Task" {
+		return nil, fmt.Errorf("expected a Task in %s, got: %s", source, task.Kind)
+	}
+	return &task.Spec, nil
+}
+
+// Build returns a TaskRun that runs the Task over the provided kontext to publish
+// the target tag.  We substitute the parameters into the Task (so that the TaskRun
+// stands alone, like those of our other builders), and the path of its workspaces
+// with /workspace, where the source is.
+func Build(ctx context.Context, sourceSteps []tknv1beta1.Step, target name.Tag, opt Options) (*tknv1beta1.TaskRun, error) {
+	hasDigest := false
+	for _, result := range opt.Task.Results {
+		if result.Name == builds.DigestResult {
+			hasDigest = true
+		}
+	}
+	if !hasDigest {
+		return nil, fmt.Errorf("the Task of %s:// references does not declare the %s result", opt.Builder, builds.DigestResult)
+	}
+
+	contextPath := strings.Trim(opt.Path, "/")
+	if contextPath == "" {
+		contextPath = "."
+	}
+	values := map[string]string{
+		builds.ImageParam: target.Name(),
+		ContextParam:      contextPath,
+	}
+	for k, v := range opt.Params {
+		values[k] = v
+	}
+
+	spec := opt.Task.DeepCopy()
+	declared := make(map[string]bool, len(spec.Params))
+	oldnew := make([]string, 0, 2*(len(spec.Params)+len(spec.Workspaces)))
+	for _, p := range spec.Params {
+		declared[p.Name] = true
+		if p.Type == tknv1beta1.ParamTypeArray {
+			return nil, fmt.Errorf("the Task of %s:// references has array parameter %s, which we do not support", opt.Builder, p.Name)
+		}
+		value, ok := values[p.Name]
+		if !ok {
+			if p.Default == nil {
+				return nil, fmt.Errorf("no value for parameter %s of the Task of %s:// references", p.Name, opt.Builder)
+			}
+			value = p.Default.StringVal
+		}
+		// The values are substituted within JSON strings.
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		oldnew = append(oldnew, "$(params."+p.Name+")", string(b[1:len(b)-1]))
+	}
+	if !declared[builds.ImageParam] {
+		return nil, fmt.Errorf("the Task of %s:// references does not declare the %s parameter", opt.Builder, builds.ImageParam)
+	}
+	for k := range opt.Params {
+		if !declared[k] {
+			return nil, fmt.Errorf("the Task of %s:// references has no parameter %s", opt.Builder, k)
+		}
+	}
+	for _, w := range spec.Workspaces {
+		oldnew = append(oldnew, "$(workspaces."+w.Name+".path)", builds.WorkspaceDir)
+	}
+	spec.Params = nil
+	spec.Workspaces = nil
+
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	spec = &tknv1beta1.TaskSpec{}
+	if err := json.Unmarshal([]byte(strings.NewReplacer(oldnew...).Replace(string(b))), spec); err != nil {
+		return nil, err
+	}
+	spec.Steps = append(append([]tknv1beta1.Step{}, sourceSteps...), spec.Steps...)
+
+	return &tknv1beta1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: opt.Builder + "-",
+			Labels: map[string]string{
+				builds.ManagedByLabel: builds.ManagedBy,
+				builds.BuilderLabel:   opt.Builder,
+			},
+			Annotations: map[string]string{
+				builds.ImageAnnotation: target.String(),
+			},
+		},
+		Spec: tknv1beta1.TaskRunSpec{
+			PodTemplate: &tknv1beta1.PodTemplate{
+				EnableServiceLinks: ptr.Bool(false),
+			},
+			TaskSpec: spec,
+		},
+	}, nil
+}
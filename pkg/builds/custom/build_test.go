@@ -0,0 +1,137 @@
+package custom_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/builds/custom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const jibTask = `apiVersion: tekton.dev/v1beta1
+kind: Task
+metadata:
+  name: jib
+spec:
+  params:
+  - name: IMAGE
+  - name: CONTEXT
+  - name: MAVEN_IMAGE
+    default: maven:3-openjdk-11
+  workspaces:
+  - name: source
+  results:
+  - name: IMAGE-DIGEST
+  steps:
+  - name: build
+    image: $(params.MAVEN_IMAGE)
+    workingDir: $(workspaces.source.path)/$(params.CONTEXT)
+    script: mvn compile jib:build -Dimage="$(params.IMAGE)"
+`
+
+func TestBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "jib.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(jibTask), 0644))
+	task, err := custom.LoadFile(file)
+	require.NoError(t, err)
+
+	target, err := name.NewTag("gcr.io/foo/bar:v1")
+	require.NoError(t, err)
+	sourceSteps := []tknv1beta1.Step{{Container: corev1.Container{Name: "extract-bundle"}}}
+
+	tr, err := custom.Build(context.TODO(), sourceSteps, target, custom.Options{
+		Builder: "jib",
+		Task:    task,
+		Path:    "/svc/app",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "jib", tr.Labels[builds.BuilderLabel])
+	spec := tr.Spec.TaskSpec
+	assert.Empty(t, spec.Params)
+	assert.Empty(t, spec.Workspaces)
+	require.Len(t, spec.Steps, 2)
+	assert.Equal(t, "extract-bundle", spec.Steps[0].Name)
+	assert.Equal(t, "maven:3-openjdk-11", spec.Steps[1].Image)
+	assert.Equal(t, "/workspace/svc/app", spec.Steps[1].WorkingDir)
+	assert.Equal(t, `mvn compile jib:build -Dimage="gcr.io/foo/bar:v1"`, spec.Steps[1].Script)
+
+	tr, err = custom.Build(context.TODO(), sourceSteps, target, custom.Options{
+		Builder: "jib",
+		Task:    task,
+		Params:  map[string]string{"MAVEN_IMAGE": "maven:3-openjdk-17"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "maven:3-openjdk-17", tr.Spec.TaskSpec.Steps[1].Image)
+	assert.Equal(t, "/workspace/.", tr.Spec.TaskSpec.Steps[1].WorkingDir)
+
+	_, err = custom.Build(context.TODO(), sourceSteps, target, custom.Options{
+		Builder: "jib",
+		Task:    task,
+		Params:  map[string]string{"NOPE": "1"},
+	})
+	assert.Error(t, err, "the Task has no such parameter")
+
+	noDigest := task.DeepCopy()
+	noDigest.Results = nil
+	_, err = custom.Build(context.TODO(), sourceSteps, target, custom.Options{
+		Builder: "jib",
+		Task:    noDigest,
+	})
+	assert.Error(t, err, "the Task does not declare the digest result")
+}
+
+func TestLoadBundle(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "jib", Mode: 0644, Size: int64(len(jibTask))}))
+	_, err = tw.Write([]byte(jibTask))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	layer, err := tarball.LayerFromReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	img, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer: layer,
+		Annotations: map[string]string{
+			"dev.tekton.image.apiVersion": "v1beta1",
+			"dev.tekton.image.kind":       "task",
+			"dev.tekton.image.name":       "jib",
+		},
+	})
+	require.NoError(t, err)
+	bundle := u.Host + "/builders/bundle:v1"
+	tag, err := name.NewTag(bundle)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(tag, img))
+
+	task, err := custom.LoadBundle(context.TODO(), bundle, "jib")
+	require.NoError(t, err)
+	assert.Equal(t, "build", task.Steps[0].Name)
+
+	_, err = custom.LoadBundle(context.TODO(), bundle, "bazel")
+	assert.Error(t, err, "the bundle has no such Task")
+}
@@ -18,9 +18,15 @@ package dockerfile
 
 import (
 	"context"
+	"fmt"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
 	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -33,10 +39,47 @@ const (
 
 	// DigestFile default digest file name
 	DigestFile = "/tekton/results/IMAGE-DIGEST"
+
+	// StepName is the name of the step that runs the Dockerfile engine.
+	StepName = "build-and-push"
+
+	// EngineKaniko, EngineBuildKit and EngineBuildah are the engines with
+	// which we can build Dockerfiles.
+	EngineKaniko   = "kaniko"
+	EngineBuildKit = "buildkit"
+	EngineBuildah  = "buildah"
+
+	// WarmStepName is the name of the step that warms kaniko's cache of base images.
+	WarmStepName = "warm-cache"
+
+	// CacheDir is where kaniko's cache of base images is mounted.
+	CacheDir = "/cache"
+
+	// SecretsDir is the directory under which the build secrets are mounted, each
+	// in a directory named after its Secret (e.g. /run/secrets/npm/token).  As
+	// volume mounts, kaniko leaves them out of the image, while BuildKit and
+	// Buildah make each key available to RUN --mount=type=secret,id=KEY.
+	SecretsDir = "/run/secrets"
+
+	// DefaultCacheTTL is how long cached layers remain valid, unless configured otherwise.
+	DefaultCacheTTL = 24 * time.Hour
 )
 
+var (
+	// WarmerImage is the path to the kaniko warmer image we use to warm the cache of base images,
+	// unless the kaniko image is a release, in which case we use the warmer of that release.
+	WarmerImage = "gcr.io/kaniko-project/warmer:v1.3.0"
+)
+
+// Engines are the engines with which we can build Dockerfiles.
+var Engines = []string{EngineKaniko, EngineBuildKit, EngineBuildah}
+
 // Options holds configuration options specific to Dockerfile builds
 type Options struct {
+	// Engine is the engine with which to build the Dockerfile, which defaults
+	// to kaniko.
+	Engine string
+
 	// Dockerfile is the path to the Dockerfile within the build context.
 	Dockerfile string
 
@@ -51,22 +94,94 @@ type Options struct {
 
 	// The extra kaniko arguments for handling things like insecure registries
 	KanikoArgs []string
+
+	// BuildArgs are the values of the Dockerfile's ARGs.
+	BuildArgs map[string]string
+
+	// Target is the stage of a multi-stage Dockerfile to build.
+	Target string
+
+	// Labels are the labels to add to the image.
+	Labels map[string]string
+
+	// Secrets are the names of the Kubernetes Secrets to make available to the
+	// build, under SecretsDir.
+	Secrets []string
+
+	// NoCache disables all of the engine's caching.
+	NoCache bool
+
+	// CacheRepo is the repository in which the engine caches layers, which
+	// defaults to the target's repository suffixed with /cache.
+	CacheRepo string
+
+	// CacheTTL is how long layers cached by kaniko remain valid, which defaults
+	// to DefaultCacheTTL.
+	CacheTTL time.Duration
+
+	// WarmImages are the base images with which to warm kaniko's cache of base
+	// images before the build.
+	WarmImages []string
+
+	// CachePVC is the name of a PersistentVolumeClaim holding kaniko's cache of
+	// base images, so that it persists across builds.  Without one, the cache is
+	// only shared by the steps of the build.
+	CachePVC string
+}
+
+// engine produces the steps that build the Dockerfile and publish the image to the
+// target, writing its digest to the digest file, along with the volumes those steps
+// use and the annotations the pod running them needs.  The last step is the one
+// named StepName, into which we mount the build secrets.
+type engine func(target name.Tag, opts Options, digestFile string) ([]tknv1beta1.Step, []corev1.Volume, map[string]string)
+
+var engines = map[string]engine{
+	EngineKaniko:   kaniko,
+	EngineBuildKit: buildkit,
+	EngineBuildah:  buildah,
 }
 
 // Build returns a TaskRun suitable for performing a Dockerfile build over the
 // provided kontext and publishing to the target tag.
 func Build(ctx context.Context, sourceSteps []tknv1beta1.Step, target name.Tag, opts Options) *tknv1beta1.TaskRun {
-	image := opts.KanikoImage
-	if image == "" {
-		image = KanikoImage
-	}
 	digestFile := opts.DigestFile
 	if digestFile == "" {
 		digestFile = DigestFile
 	}
-	return &tknv1beta1.TaskRun{
+	build, ok := engines[opts.Engine]
+	if !ok {
+		build = kaniko
+	}
+	steps, volumes, annotations := build(target, opts, digestFile)
+
+	step := &steps[len(steps)-1]
+	for i, secret := range opts.Secrets {
+		volume := corev1.Volume{
+			Name: fmt.Sprint("build-secret-", i),
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: secret,
+				},
+			},
+		}
+		volumes = append(volumes, volume)
+		step.VolumeMounts = append(step.VolumeMounts, corev1.VolumeMount{
+			Name:      volume.Name,
+			MountPath: filepath.Join(SecretsDir, secret),
+			ReadOnly:  true,
+		})
+	}
+
+	tr := &tknv1beta1.TaskRun{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: "dockerfile-",
+			Labels: map[string]string{
+				builds.ManagedByLabel: builds.ManagedBy,
+				builds.BuilderLabel:   "dockerfile",
+			},
+			Annotations: map[string]string{
+				builds.ImageAnnotation: target.String(),
+			},
 		},
 		Spec: tknv1beta1.TaskRunSpec{
 			PodTemplate: &tknv1beta1.PodTemplate{
@@ -78,32 +193,72 @@ func Build(ctx context.Context, sourceSteps []tknv1beta1.Step, target name.Tag,
 					Name: "IMAGE-DIGEST",
 				}},
 
-				Steps: append(sourceSteps, tknv1beta1.Step{
-					Container: corev1.Container{
-						Name:  "build-and-push",
-						Image: image,
-						Env: []corev1.EnvVar{{
-							Name:  "DOCKER_CONFIG",
-							Value: "/tekton/home/.docker",
-						}},
-						Args: append([]string{
-							"--dockerfile=" + filepath.Join("/workspace", opts.Path, opts.Dockerfile),
-
-							// We expand into /workspace, and publish to the specified
-							// output resource image.
-							"--context=" + filepath.Join("/workspace", opts.Path),
-							"--destination=" + target.Name(),
-
-							// Write out the digest to the appropriate result file.
-							"--digest-file=" + digestFile,
-
-							// Enable kanikache to get incremental builds
-							"--cache=true",
-							"--cache-ttl=24h",
-						}, opts.KanikoArgs...),
-					},
-				}),
+				Steps:   append(append([]tknv1beta1.Step{}, sourceSteps...), steps...),
+				Volumes: volumes,
 			},
 		},
 	}
+	for k, v := range annotations {
+		tr.Annotations[k] = v
+	}
+	return tr
+}
+
+// cacheRepo returns the repository in which the engine caches layers.
+func cacheRepo(target name.Tag, opts Options) string {
+	if opts.CacheRepo != "" {
+		return opts.CacheRepo
+	}
+	return target.Context().String() + "/cache"
+}
+
+// unconfined returns the annotations that lift the AppArmor and seccomp profiles
+// from the step, which the rootless engines need to create their sandboxes.
+func unconfined(step string) map[string]string {
+	container := "step-" + step
+	return map[string]string{
+		"container.apparmor.security.beta.kubernetes.io/" + container: "unconfined",
+		"container.seccomp.security.alpha.kubernetes.io/" + container: "unconfined",
+	}
+}
+
+// secretsScript returns the shell that collects the keys of the build secrets into
+// $SECRETS, as flags of the form: --secret id=KEY,src=FILE
+func secretsScript(secrets []string) string {
+	if len(secrets) == 0 {
+		return ""
+	}
+	dirs := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		dir := filepath.Join(SecretsDir, secret)
+		// Skip the ..data entries through which Kubernetes updates the keys.
+		dirs = append(dirs, shellQuote(dir)+"/* "+shellQuote(dir)+"/.[!.]*")
+	}
+	return "SECRETS=\"\"\n" +
+		"for f in " + strings.Join(dirs, " ") + "; do\n" +
+		"  [ -e \"$f\" ] || continue\n" +
+		"  SECRETS=\"$SECRETS --secret id=$(basename \"$f\"),src=$f\"\n" +
+		"done\n"
+}
+
+// shellQuote quotes the string (when it needs quoting) for use as a single
+// word in a shell script.
+func shellQuote(s string) string {
+	if s != "" && !unsafeShellChars.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+var unsafeShellChars = regexp.MustCompile(`[^\w@%+=:,./-]`)
+
+// sortedKeys returns the keys of the map in order, so that the
+// TaskRuns we produce are deterministic.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
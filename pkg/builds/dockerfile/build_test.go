@@ -0,0 +1,154 @@
+package dockerfile_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/builds/dockerfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestBuildCache(t *testing.T) {
+	target, err := name.NewTag("gcr.io/foo/bar:latest")
+	require.NoError(t, err)
+
+	tr := dockerfile.Build(context.TODO(), nil, target, dockerfile.Options{
+		Dockerfile: "Dockerfile",
+		CacheRepo:  "gcr.io/foo/cache",
+		CacheTTL:   time.Hour,
+		WarmImages: []string{"golang:1.15"},
+		CachePVC:   "kaniko-cache",
+	})
+	steps := tr.Spec.TaskSpec.Steps
+	require.Len(t, steps, 2)
+	assert.Equal(t, dockerfile.WarmStepName, steps[0].Name)
+	assert.Equal(t, []string{"--cache-dir=/cache", "--image=golang:1.15"}, steps[0].Args)
+	assert.Subset(t, steps[1].Args, []string{"--cache=true", "--cache-ttl=1h0m0s", "--cache-repo=gcr.io/foo/cache", "--cache-dir=/cache"})
+	require.Len(t, tr.Spec.TaskSpec.Volumes, 1)
+	assert.Equal(t, "kaniko-cache", tr.Spec.TaskSpec.Volumes[0].PersistentVolumeClaim.ClaimName)
+
+	tr = dockerfile.Build(context.TODO(), nil, target, dockerfile.Options{
+		Dockerfile: "Dockerfile",
+		NoCache:    true,
+		WarmImages: []string{"golang:1.15"},
+	})
+	steps = tr.Spec.TaskSpec.Steps
+	require.Len(t, steps, 1)
+	for _, arg := range steps[0].Args {
+		assert.NotContains(t, arg, "--cache")
+	}
+	assert.Empty(t, tr.Spec.TaskSpec.Volumes)
+}
+
+func TestBuildWarmerImage(t *testing.T) {
+	target, err := name.NewTag("gcr.io/foo/bar:latest")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		kaniko string
+		want   string
+	}{{
+		name: "default",
+		want: dockerfile.WarmerImage,
+	}, {
+		name:   "release",
+		kaniko: "gcr.io/kaniko-project/executor:v1.6.0",
+		want:   "gcr.io/kaniko-project/warmer:v1.6.0",
+	}, {
+		name:   "debug release",
+		kaniko: "gcr.io/kaniko-project/executor:debug-v1.6.0",
+		want:   "gcr.io/kaniko-project/warmer:v1.6.0",
+	}, {
+		name:   "digest",
+		kaniko: "gcr.io/kaniko-project/executor@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		want:   dockerfile.WarmerImage,
+	}, {
+		name:   "mirror",
+		kaniko: "registry.example.com/kaniko/executor:v1.6.0",
+		want:   dockerfile.WarmerImage,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr := dockerfile.Build(context.TODO(), nil, target, dockerfile.Options{
+				Dockerfile:  "Dockerfile",
+				KanikoImage: test.kaniko,
+				WarmImages:  []string{"golang:1.15"},
+				CachePVC:    "kaniko-cache",
+			})
+			steps := tr.Spec.TaskSpec.Steps
+			require.Len(t, steps, 2)
+			assert.Equal(t, test.want, steps[0].Image)
+		})
+	}
+}
+
+func TestBuildArgsAndSecrets(t *testing.T) {
+	target, err := name.NewTag("gcr.io/foo/bar:latest")
+	require.NoError(t, err)
+
+	tr := dockerfile.Build(context.TODO(), nil, target, dockerfile.Options{
+		Dockerfile: "Dockerfile",
+		BuildArgs:  map[string]string{"B": "2", "A": "1"},
+		Target:     "prod",
+		Labels:     map[string]string{"team": "x"},
+		Secrets:    []string{"npm"},
+	})
+	step := tr.Spec.TaskSpec.Steps[0]
+	assert.Subset(t, step.Args, []string{"--build-arg=A=1", "--build-arg=B=2", "--target=prod", "--label=team=x"})
+	require.Len(t, step.VolumeMounts, 1)
+	assert.Equal(t, dockerfile.SecretsDir+"/npm", step.VolumeMounts[0].MountPath)
+	require.Len(t, tr.Spec.TaskSpec.Volumes, 1)
+	assert.Equal(t, "npm", tr.Spec.TaskSpec.Volumes[0].Secret.SecretName)
+}
+
+func TestBuildEngines(t *testing.T) {
+	target, err := name.NewTag("gcr.io/foo/bar:latest")
+	require.NoError(t, err)
+
+	for _, engine := range []string{dockerfile.EngineBuildKit, dockerfile.EngineBuildah} {
+		t.Run(engine, func(t *testing.T) {
+			tr := dockerfile.Build(context.TODO(), nil, target, dockerfile.Options{
+				Engine:     engine,
+				Dockerfile: "Dockerfile",
+				BuildArgs:  map[string]string{"A": "1 2"},
+				Secrets:    []string{"npm"},
+			})
+			steps := tr.Spec.TaskSpec.Steps
+			require.Len(t, steps, 1)
+			assert.Equal(t, dockerfile.StepName, steps[0].Name)
+			assert.Contains(t, steps[0].Script, "A=1 2'", "build args are quoted")
+			assert.Contains(t, steps[0].Script, "--secret id=")
+			assert.Contains(t, steps[0].Script, dockerfile.DigestFile)
+			assert.Equal(t, int64(1000), *steps[0].SecurityContext.RunAsUser)
+			assert.Equal(t, "unconfined", tr.Annotations["container.seccomp.security.alpha.kubernetes.io/step-"+dockerfile.StepName])
+		})
+	}
+}
+
+func TestBuildCredentials(t *testing.T) {
+	target, err := name.NewTag("gcr.io/foo/bar:latest")
+	require.NoError(t, err)
+
+	for _, engine := range dockerfile.Engines {
+		t.Run(engine, func(t *testing.T) {
+			tr := dockerfile.Build(context.TODO(), nil, target, dockerfile.Options{
+				Engine:     engine,
+				Dockerfile: "Dockerfile",
+			})
+			cancel, err := builds.WithServiceAccount("me", target)(builds.WithDryRun(context.TODO()), tr)
+			require.NoError(t, err)
+			defer cancel()
+
+			step := tr.Spec.TaskSpec.Steps[len(tr.Spec.TaskSpec.Steps)-1]
+			require.Len(t, step.VolumeMounts, 1, "the credentials are mounted")
+			assert.Contains(t, step.Env, corev1.EnvVar{Name: "DOCKER_CONFIG", Value: step.VolumeMounts[0].MountPath})
+		})
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/ptr"
+)
+
+var (
+	// BuildahImage is the path to the Buildah image we use for Dockerfile builds
+	// with the buildah engine.
+	BuildahImage = "quay.io/buildah/stable:v1.28.0"
+)
+
+// buildah implements engine, building the Dockerfile with a rootless Buildah.
+func buildah(target name.Tag, opts Options, digestFile string) ([]tknv1beta1.Step, []corev1.Volume, map[string]string) {
+	bud := []string{
+		"buildah", "bud",
+		"--format=oci",
+		"--file=" + filepath.Join("/workspace", opts.Path, opts.Dockerfile),
+		"--tag=" + target.Name(),
+	}
+	for _, k := range sortedKeys(opts.BuildArgs) {
+		bud = append(bud, "--build-arg="+k+"="+opts.BuildArgs[k])
+	}
+	if opts.Target != "" {
+		bud = append(bud, "--target="+opts.Target)
+	}
+	for _, k := range sortedKeys(opts.Labels) {
+		bud = append(bud, "--label="+k+"="+opts.Labels[k])
+	}
+	if opts.NoCache {
+		bud = append(bud, "--no-cache")
+	} else {
+		repo := cacheRepo(target, opts)
+		bud = append(bud, "--layers", "--cache-from="+repo, "--cache-to="+repo)
+	}
+	bud = append(bud, filepath.Join("/workspace", opts.Path))
+
+	push := []string{
+		"buildah", "push",
+		// Write out the digest to the appropriate result file.
+		"--digestfile=" + digestFile,
+		target.Name(), "docker://" + target.Name(),
+	}
+	for i, w := range bud {
+		bud[i] = shellQuote(w)
+	}
+	for i, w := range push {
+		push[i] = shellQuote(w)
+	}
+
+	script := "#!/bin/sh\nset -e\n" +
+		secretsScript(opts.Secrets) +
+		strings.Join(bud, " ") + " $SECRETS\n" +
+		strings.Join(push, " ") + "\n"
+
+	step := tknv1beta1.Step{
+		Container: corev1.Container{
+			Name:  StepName,
+			Image: BuildahImage,
+			Env: []corev1.EnvVar{{
+				// This is where builds.WithServiceAccount mounts credentials.
+				Name:  "DOCKER_CONFIG",
+				Value: "/tekton/home/.docker",
+			}, {
+				Name:  "REGISTRY_AUTH_FILE",
+				Value: "$(DOCKER_CONFIG)/config.json",
+			}, {
+				// Rootless Buildah cannot use overlay or create namespaced
+				// sandboxes within an unprivileged container.
+				Name:  "STORAGE_DRIVER",
+				Value: "vfs",
+			}, {
+				Name:  "BUILDAH_ISOLATION",
+				Value: "chroot",
+			}},
+			SecurityContext: &corev1.SecurityContext{
+				// The "build" user of the Buildah image.
+				RunAsUser:  ptr.Int64(1000),
+				RunAsGroup: ptr.Int64(1000),
+			},
+		},
+		Script: script,
+	}
+	return []tknv1beta1.Step{step}, nil, unconfined(StepName)
+}
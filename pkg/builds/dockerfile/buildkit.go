@@ -0,0 +1,93 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/ptr"
+)
+
+var (
+	// BuildKitImage is the path to the rootless BuildKit image we use for Dockerfile
+	// builds with the buildkit engine.
+	BuildKitImage = "moby/buildkit:v0.8.1-rootless"
+)
+
+// buildkit implements engine, building the Dockerfile with a rootless BuildKit.
+func buildkit(target name.Tag, opts Options, digestFile string) ([]tknv1beta1.Step, []corev1.Volume, map[string]string) {
+	dockerfile := filepath.Join("/workspace", opts.Path, opts.Dockerfile)
+	metadataFile := "/tmp/metadata.json"
+
+	words := []string{
+		"buildctl-daemonless.sh", "build",
+		"--frontend=dockerfile.v0",
+		"--local", "context=" + filepath.Join("/workspace", opts.Path),
+		"--local", "dockerfile=" + filepath.Dir(dockerfile),
+		"--opt", "filename=" + filepath.Base(dockerfile),
+		"--output", "type=image,name=" + target.Name() + ",push=true",
+		"--metadata-file", metadataFile,
+	}
+	for _, k := range sortedKeys(opts.BuildArgs) {
+		words = append(words, "--opt", "build-arg:"+k+"="+opts.BuildArgs[k])
+	}
+	if opts.Target != "" {
+		words = append(words, "--opt", "target="+opts.Target)
+	}
+	for _, k := range sortedKeys(opts.Labels) {
+		words = append(words, "--opt", "label:"+k+"="+opts.Labels[k])
+	}
+	if !opts.NoCache {
+		ref := "type=registry,ref=" + cacheRepo(target, opts) + ":buildkit"
+		words = append(words, "--export-cache", ref+",mode=max", "--import-cache", ref)
+	}
+	for i, w := range words {
+		words[i] = shellQuote(w)
+	}
+
+	script := "#!/bin/sh\nset -e\n" +
+		secretsScript(opts.Secrets) +
+		strings.Join(words, " ") + " $SECRETS\n" +
+		// Write out the digest to the appropriate result file.
+		`sed -n 's/.*"containerimage.digest": *"\([^"]*\)".*/\1/p' ` + metadataFile + " > " + shellQuote(digestFile) + "\n"
+
+	step := tknv1beta1.Step{
+		Container: corev1.Container{
+			Name:  StepName,
+			Image: BuildKitImage,
+			Env: []corev1.EnvVar{{
+				Name:  "DOCKER_CONFIG",
+				Value: "/tekton/home/.docker",
+			}, {
+				// Rootless BuildKit cannot create a process sandbox within
+				// an unprivileged container.
+				Name:  "BUILDKITD_FLAGS",
+				Value: "--oci-worker-no-process-sandbox",
+			}},
+			SecurityContext: &corev1.SecurityContext{
+				RunAsUser:  ptr.Int64(1000),
+				RunAsGroup: ptr.Int64(1000),
+			},
+		},
+		Script: script,
+	}
+	return []tknv1beta1.Step{step}, nil, unconfined(StepName)
+}
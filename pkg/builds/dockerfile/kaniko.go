@@ -0,0 +1,138 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// kaniko implements engine, building the Dockerfile with kaniko.
+func kaniko(target name.Tag, opts Options, digestFile string) ([]tknv1beta1.Step, []corev1.Volume, map[string]string) {
+	image := opts.KanikoImage
+	if image == "" {
+		image = KanikoImage
+	}
+
+	step := tknv1beta1.Step{
+		Container: corev1.Container{
+			Name:  StepName,
+			Image: image,
+			Env: []corev1.EnvVar{{
+				Name:  "DOCKER_CONFIG",
+				Value: "/tekton/home/.docker",
+			}},
+			Args: []string{
+				"--dockerfile=" + filepath.Join("/workspace", opts.Path, opts.Dockerfile),
+
+				// We expand into /workspace, and publish to the specified
+				// output resource image.
+				"--context=" + filepath.Join("/workspace", opts.Path),
+				"--destination=" + target.Name(),
+
+				// Write out the digest to the appropriate result file.
+				"--digest-file=" + digestFile,
+			},
+		},
+	}
+	for _, k := range sortedKeys(opts.BuildArgs) {
+		step.Args = append(step.Args, "--build-arg="+k+"="+opts.BuildArgs[k])
+	}
+	if opts.Target != "" {
+		step.Args = append(step.Args, "--target="+opts.Target)
+	}
+	for _, k := range sortedKeys(opts.Labels) {
+		step.Args = append(step.Args, "--label="+k+"="+opts.Labels[k])
+	}
+
+	var steps []tknv1beta1.Step
+	var volumes []corev1.Volume
+	if !opts.NoCache {
+		// Enable kanikache to get incremental builds
+		ttl := opts.CacheTTL
+		if ttl == 0 {
+			ttl = DefaultCacheTTL
+		}
+		step.Args = append(step.Args, "--cache=true", "--cache-ttl="+ttl.String())
+		if opts.CacheRepo != "" {
+			step.Args = append(step.Args, "--cache-repo="+opts.CacheRepo)
+		}
+
+		if len(opts.WarmImages) > 0 || opts.CachePVC != "" {
+			volume := corev1.Volume{
+				Name: "kaniko-cache",
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{},
+				},
+			}
+			if opts.CachePVC != "" {
+				volume.VolumeSource = corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: opts.CachePVC,
+					},
+				}
+			}
+			volumes = append(volumes, volume)
+			mount := corev1.VolumeMount{
+				Name:      volume.Name,
+				MountPath: CacheDir,
+			}
+			step.Args = append(step.Args, "--cache-dir="+CacheDir)
+			step.VolumeMounts = append(step.VolumeMounts, mount)
+
+			if len(opts.WarmImages) > 0 {
+				warm := tknv1beta1.Step{
+					Container: corev1.Container{
+						Name:  WarmStepName,
+						Image: warmerImage(image),
+						Env: []corev1.EnvVar{{
+							Name:  "DOCKER_CONFIG",
+							Value: "/tekton/home/.docker",
+						}},
+						Args:         []string{"--cache-dir=" + CacheDir},
+						VolumeMounts: []corev1.VolumeMount{mount},
+					},
+				}
+				for _, img := range opts.WarmImages {
+					warm.Args = append(warm.Args, "--image="+img)
+				}
+				steps = append(steps, warm)
+			}
+		}
+	}
+	step.Args = append(step.Args, opts.KanikoArgs...)
+
+	return append(steps, step), volumes, nil
+}
+
+// warmerImage returns the kaniko warmer image of the same release as the kaniko
+// executor image, so the two agree on the layout of the cache, or WarmerImage.
+func warmerImage(executor string) string {
+	tag, err := name.NewTag(executor, name.WeakValidation)
+	if err != nil || tag.Context().Name() != "gcr.io/kaniko-project/executor" {
+		return WarmerImage
+	}
+	release := strings.TrimPrefix(tag.TagStr(), "debug-")
+	if !strings.HasPrefix(release, "v") {
+		return WarmerImage
+	}
+	return "gcr.io/kaniko-project/warmer:" + release
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ImageParam is the name of the parameter through which Tasks emitted by the
+	// DryRunExecutor receive the image to publish.
+	ImageParam = "IMAGE"
+
+	// ImageRepoParam is the name of the parameter through which Tasks emitted by
+	// the DryRunExecutor receive the repository of the image, for steps that use
+	// it without the tag (e.g. ko's KO_DOCKER_REPO, or the cache repositories
+	// beneath it).
+	ImageRepoParam = "IMAGE-REPO"
+)
+
+// dryRunKey is used as the key for associating dry-run mode with a context.Context.
+type dryRunKey struct{}
+
+// WithDryRun notes on the context that the builds are not actually being run, so
+// CancelableOptions should mutate the TaskRun without creating anything.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, struct{}{})
+}
+
+// IsDryRun checks whether the context is for a dry-run.
+func IsDryRun(ctx context.Context) bool {
+	return ctx.Value(dryRunKey{}) != nil
+}
+
+// dryRunMu serializes the output of things printed in parallel.
+var dryRunMu sync.Mutex
+
+// DryRunExecutor prints the TaskRuns it is given (or the Tasks they embed) with
+// its options applied, rather than running them.
+type DryRunExecutor struct {
+	// Out is where the TaskRuns are printed.
+	Out io.Writer
+
+	// Format is the format in which to print them, either "yaml" or "json".
+	Format string
+
+	// EmitTask prints a reusable Task, which takes the image to publish as
+	// a parameter, in place of the TaskRun.
+	EmitTask bool
+
+	// Options are applied to the TaskRun prior to printing it.
+	Options []CancelableOption
+}
+
+// DryRunExecutor implements Executor
+var _ Executor = (*DryRunExecutor)(nil)
+
+// Execute implements Executor
+func (de *DryRunExecutor) Execute(ctx context.Context, image string, tr *tknv1beta1.TaskRun) (name.Digest, error) {
+	ctx = WithDryRun(ctx)
+	for _, o := range de.Options {
+		cancel, err := o(ctx, tr)
+		if err != nil {
+			return name.Digest{}, err
+		}
+		defer cancel()
+	}
+
+	var obj interface{} = tr
+	if de.EmitTask {
+		task, err := ToTask(image, tr)
+		if err != nil {
+			return name.Digest{}, err
+		}
+		obj = task
+	} else {
+		tr.TypeMeta = metav1.TypeMeta{
+			APIVersion: tknv1beta1.SchemeGroupVersion.String(),
+			Kind:       "TaskRun",
+		}
+	}
+
+	if err := Print(de.Out, de.Format, obj); err != nil {
+		return name.Digest{}, err
+	}
+
+	// Nothing was built, so return a placeholder digest.
+	return name.NewDigest(image + "@sha256:" + strings.Repeat("0", 64))
+}
+
+// Print writes the object to out in the given format, either "yaml" or "json",
+// ensuring that objects printed concurrently are not interleaved.
+func Print(out io.Writer, format string, obj interface{}) error {
+	var s string
+	switch format {
+	case "", "yaml":
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		s = "---\n" + string(b)
+	case "json":
+		b, err := json.MarshalIndent(obj, "", "  ")
+		if err != nil {
+			return err
+		}
+		s = string(b) + "\n"
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+	_, err := io.WriteString(out, s)
+	return err
+}
+
+var invalidNameChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// ToTask turns the TaskRun's embedded TaskSpec into a standalone Task, which
+// is named after the builder and image, and which receives the image to
+// publish via the IMAGE parameter and, when its steps use it, the repository
+// of the image via the IMAGE-REPO parameter.
+func ToTask(image string, tr *tknv1beta1.TaskRun) (*tknv1beta1.Task, error) {
+	if tr.Spec.TaskSpec == nil {
+		return nil, fmt.Errorf("TaskRun %s has no inline TaskSpec", tr.GenerateName)
+	}
+	ref, err := name.ParseReference(image, name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+	repo := ref.Context().RepositoryStr()
+	repo = repo[strings.LastIndex(repo, "/")+1:]
+
+	// Builders publish under the repository of the tag they were given, which
+	// differs from that of the image when they name it themselves (e.g. ko).
+	target := ref
+	if annotation, ok := tr.Annotations[ImageAnnotation]; ok {
+		if target, err = name.ParseReference(annotation, name.WeakValidation); err != nil {
+			return nil, err
+		}
+	}
+
+	// Substitute the image we were given for the parameter, and then its
+	// repository in the references derived from it.
+	spec := tr.Spec.TaskSpec.DeepCopy()
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	s, _ := replaceReference(string(b), ref.Name(), "$(params."+ImageParam+")", "")
+	s, usesRepo := replaceReference(s, target.Context().Name(), "$(params."+ImageRepoParam+")", "/:@")
+	spec = &tknv1beta1.TaskSpec{}
+	if err := json.Unmarshal([]byte(s), spec); err != nil {
+		return nil, err
+	}
+	spec.Params = append(spec.Params, tknv1beta1.ParamSpec{
+		Name:        ImageParam,
+		Type:        tknv1beta1.ParamTypeString,
+		Description: "The image to publish.",
+		Default:     tknv1beta1.NewArrayOrString(ref.Name()),
+	})
+	if usesRepo {
+		spec.Params = append(spec.Params, tknv1beta1.ParamSpec{
+			Name:        ImageRepoParam,
+			Type:        tknv1beta1.ParamTypeString,
+			Description: "The repository under which to publish the image (and its caches).",
+			Default:     tknv1beta1.NewArrayOrString(target.Context().Name()),
+		})
+	}
+
+	return &tknv1beta1.Task{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: tknv1beta1.SchemeGroupVersion.String(),
+			Kind:       "Task",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      strings.Trim(invalidNameChars.ReplaceAllString(tr.GenerateName+repo, "-"), "-"),
+			Namespace: tr.Namespace,
+		},
+		Spec: *spec,
+	}, nil
+}
+
+// replaceReference returns a copy of s with the occurrences of the reference
+// replaced, and whether there were any.  They must start a reference, e.g. the
+// value of --destination=IMAGE or docker://IMAGE, and end it, unless they are
+// followed by one of the suffixes, so that with no suffixes IMAGE is not replaced
+// in IMAGE-debug or IMAGE/cache, and with the suffixes "/:@" REPO is replaced in
+// REPO/cache:buildkit, but not in REPO-debug.
+func replaceReference(s, ref, replacement, suffixes string) (string, bool) {
+	var b strings.Builder
+	replaced := false
+	for {
+		i := strings.Index(s, ref)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), replaced
+		}
+		end := i + len(ref)
+		starts := i == 0 || !isReferenceChar(s[i-1]) || strings.HasSuffix(s[:i], "://")
+		ends := end == len(s) || !isReferenceChar(s[end]) || strings.IndexByte(suffixes, s[end]) >= 0
+		b.WriteString(s[:i])
+		if starts && ends {
+			b.WriteString(replacement)
+			replaced = true
+		} else {
+			b.WriteString(ref)
+		}
+		s = s[end:]
+	}
+}
+
+// isReferenceChar returns whether c may be part of an image reference.
+func isReferenceChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	default:
+		return strings.IndexByte("._-/:@", c) >= 0
+	}
+}
@@ -0,0 +1,181 @@
+package builds_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/builds/dockerfile"
+	"github.com/mattmoor/mink/pkg/builds/ko"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func dryRunTaskRun() *tknv1beta1.TaskRun {
+	return &tknv1beta1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kaniko-",
+			Namespace:    "default",
+		},
+		Spec: tknv1beta1.TaskRunSpec{
+			TaskSpec: &tknv1beta1.TaskSpec{
+				Steps: []tknv1beta1.Step{{
+					Container: corev1.Container{
+						Name:  "build-and-push",
+						Image: "gcr.io/kaniko-project/executor:latest",
+						Args: []string{
+							"--destination=gcr.io/foo/bar:latest",
+							"--cache-repo=gcr.io/foo/bar/cache",
+							"--debug-image=gcr.io/foo/bar:latest-debug",
+							"--other-image=gcr.io/foo/barista",
+							"--mirror=mirror.gcr.io/foo/bar:latest",
+							"docker://gcr.io/foo/bar:latest",
+						},
+						Env: []corev1.EnvVar{{
+							Name:  "IMAGE",
+							Value: "gcr.io/foo/bar:latest",
+						}},
+					},
+					Script: "echo gcr.io/foo/bar:latest\n",
+				}},
+			},
+		},
+	}
+}
+
+func TestDryRunExecutor(t *testing.T) {
+	for _, format := range []string{"yaml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var out bytes.Buffer
+			de := &builds.DryRunExecutor{
+				Out:    &out,
+				Format: format,
+				Options: []builds.CancelableOption{
+					builds.WithServiceAccount("builder"),
+				},
+			}
+			digest, err := de.Execute(context.TODO(), "gcr.io/foo/bar:latest", dryRunTaskRun())
+			require.NoError(t, err)
+			assert.Equal(t, "gcr.io/foo/bar:latest@sha256:"+strings.Repeat("0", 64), digest.String())
+
+			var tr tknv1beta1.TaskRun
+			if format == "yaml" {
+				require.True(t, bytes.HasPrefix(out.Bytes(), []byte("---\n")), "documents are separated")
+				require.NoError(t, yaml.Unmarshal(out.Bytes(), &tr))
+			} else {
+				require.NoError(t, json.Unmarshal(out.Bytes(), &tr))
+			}
+			assert.Equal(t, "TaskRun", tr.Kind)
+			assert.Equal(t, tknv1beta1.SchemeGroupVersion.String(), tr.APIVersion)
+			assert.Equal(t, "builder", tr.Spec.ServiceAccountName, "the options are applied")
+			assert.Equal(t, dryRunTaskRun().Spec.TaskSpec.Steps, tr.Spec.TaskSpec.Steps)
+		})
+	}
+
+	de := &builds.DryRunExecutor{Out: &bytes.Buffer{}, Format: "xml"}
+	_, err := de.Execute(context.TODO(), "gcr.io/foo/bar:latest", dryRunTaskRun())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported output format: xml")
+}
+
+func TestDryRunExecutorEmitTask(t *testing.T) {
+	var out bytes.Buffer
+	de := &builds.DryRunExecutor{
+		Out:      &out,
+		EmitTask: true,
+	}
+	_, err := de.Execute(context.TODO(), "gcr.io/foo/bar:latest", dryRunTaskRun())
+	require.NoError(t, err)
+
+	var task tknv1beta1.Task
+	require.NoError(t, yaml.Unmarshal(bytes.TrimPrefix(out.Bytes(), []byte("---\n")), &task))
+	assert.Equal(t, "Task", task.Kind)
+	assert.Equal(t, "kaniko-bar", task.Name)
+}
+
+func TestToTask(t *testing.T) {
+	task, err := builds.ToTask("gcr.io/foo/bar:latest", dryRunTaskRun())
+	require.NoError(t, err)
+
+	assert.Equal(t, "kaniko-bar", task.Name)
+	assert.Equal(t, "default", task.Namespace)
+	assert.Equal(t, []tknv1beta1.ParamSpec{{
+		Name:        builds.ImageParam,
+		Type:        tknv1beta1.ParamTypeString,
+		Description: "The image to publish.",
+		Default:     tknv1beta1.NewArrayOrString("gcr.io/foo/bar:latest"),
+	}, {
+		Name:        builds.ImageRepoParam,
+		Type:        tknv1beta1.ParamTypeString,
+		Description: "The repository under which to publish the image (and its caches).",
+		Default:     tknv1beta1.NewArrayOrString("gcr.io/foo/bar"),
+	}}, task.Spec.Params)
+
+	// Only whole references to the image (or references derived from its
+	// repository) are substituted.
+	step := task.Spec.Steps[0]
+	assert.Equal(t, []string{
+		"--destination=$(params.IMAGE)",
+		"--cache-repo=$(params.IMAGE-REPO)/cache",
+		"--debug-image=$(params.IMAGE-REPO):latest-debug",
+		"--other-image=gcr.io/foo/barista",
+		"--mirror=mirror.gcr.io/foo/bar:latest",
+		"docker://$(params.IMAGE)",
+	}, step.Args)
+	assert.Equal(t, "$(params.IMAGE)", step.Env[0].Value)
+	assert.Equal(t, "echo $(params.IMAGE)\n", step.Script)
+	assert.Equal(t, "gcr.io/kaniko-project/executor:latest", step.Image)
+
+	tr := dryRunTaskRun()
+	tr.Spec.TaskSpec = nil
+	_, err = builds.ToTask("gcr.io/foo/bar:latest", tr)
+	require.Error(t, err)
+}
+
+func TestToTaskKo(t *testing.T) {
+	target, err := name.NewTag("gcr.io/foo/bar:latest")
+	require.NoError(t, err)
+	opts := ko.Options{
+		ImportPath: "ko://github.com/foo/bar/cmd/baz",
+		Naming:     ko.NamingBaseImportPaths,
+	}
+	published, err := ko.Target(target, opts)
+	require.NoError(t, err)
+
+	// ko publishes beneath KO_DOCKER_REPO, which has no tag.
+	task, err := builds.ToTask(published.String(), ko.Build(context.TODO(), nil, target, opts))
+	require.NoError(t, err)
+	assert.Contains(t, task.Spec.Steps[0].Env, corev1.EnvVar{Name: "KO_DOCKER_REPO", Value: "$(params.IMAGE-REPO)"})
+	require.Len(t, task.Spec.Params, 2)
+	assert.Equal(t, builds.ImageRepoParam, task.Spec.Params[1].Name)
+	assert.Equal(t, "gcr.io/foo/bar", task.Spec.Params[1].Default.StringVal)
+
+	b, err := json.Marshal(task.Spec.Steps)
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "gcr.io/foo/bar", "the repository is not hardcoded")
+}
+
+func TestToTaskBuildKitCache(t *testing.T) {
+	target, err := name.NewTag("gcr.io/foo/bar:latest")
+	require.NoError(t, err)
+	tr := dockerfile.Build(context.TODO(), nil, target, dockerfile.Options{
+		Engine:     "buildkit",
+		Dockerfile: "Dockerfile",
+	})
+
+	task, err := builds.ToTask(target.String(), tr)
+	require.NoError(t, err)
+	b, err := json.Marshal(task.Spec.Steps)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "ref=$(params.IMAGE-REPO)/cache:buildkit")
+	assert.Contains(t, string(b), "$(params.IMAGE)")
+	assert.NotContains(t, string(b), "gcr.io/foo/bar", "neither the image nor its cache is hardcoded")
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/tektoncd/cli/pkg/options"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+// Executor is implemented by the different ways we have of running the TaskRuns
+// produced by our builders (e.g. dockerfile.Build, ko.Build or buildpacks.Build)
+// to completion.
+type Executor interface {
+	// Execute runs the provided TaskRun to completion, and returns the
+	// fully-qualified digest of the image it published as image.
+	Execute(ctx context.Context, image string, tr *tknv1beta1.TaskRun) (name.Digest, error)
+}
+
+// DigestResult is the name of the result through which our builds report the
+// digest of the image they publish.
+const DigestResult = "IMAGE-DIGEST"
+
+// producesDigest returns whether the TaskRun publishes an image, and so reports
+// its digest, which is unless it carries the NoImageAnnotation.
+func producesDigest(tr *tknv1beta1.TaskRun) bool {
+	_, ok := tr.Annotations[NoImageAnnotation]
+	return !ok
+}
+
+// TektonExecutor executes builds by creating the TaskRun on the cluster and
+// following its logs until it completes.
+type TektonExecutor struct {
+	// LogOptions controls how the logs of the TaskRun are streamed.
+	LogOptions *options.LogOptions
+
+	// Options are applied to the TaskRun prior to its creation.
+	Options []CancelableOption
+}
+
+// TektonExecutor implements Executor
+var _ Executor = (*TektonExecutor)(nil)
+
+// Execute implements Executor
+func (te *TektonExecutor) Execute(ctx context.Context, image string, tr *tknv1beta1.TaskRun) (name.Digest, error) {
+	return Run(ctx, image, tr, te.LogOptions, te.Options...)
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	tektonclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+)
+
+// GCOptions configures GarbageCollect.
+type GCOptions struct {
+	// Namespace is the namespace to clean up, or empty for all namespaces.
+	Namespace string
+
+	// OlderThan is how old the objects must be for us to remove them.
+	OlderThan time.Duration
+
+	// DryRun reports the objects that would be removed without removing them.
+	DryRun bool
+
+	// Now is the time against which the age of the objects is measured.
+	Now time.Time
+
+	// Out is where the removed objects are reported.
+	Out io.Writer
+}
+
+// collectable is a kind of object that we create in the cluster.
+type collectable struct {
+	kind   string
+	list   func(ctx context.Context, namespace string, opts metav1.ListOptions) ([]metav1.ObjectMeta, error)
+	delete func(ctx context.Context, namespace, name string) error
+}
+
+// GarbageCollect removes the objects that we label as ours (TaskRuns, PipelineRuns, pods,
+// Secrets and ServiceAccounts) which were left behind by builds that were interrupted.
+// Objects are removed once they are older than OlderThan, or have outlived the TTL in
+// their annotations, unless they are owned by another object, in which case we leave
+// them to Kubernetes to garbage collect along with their owner.  TaskRuns and
+// PipelineRuns are only removed once they have finished, as are the credentials they note.
+func GarbageCollect(ctx context.Context, client tektonclientset.Interface, kubeclient kubernetes.Interface, opts GCOptions) error {
+	// The credentials noted by the TaskRuns and PipelineRuns that are still running, which
+	// we must leave alone even if we failed to make those runs their owner.
+	secrets, serviceAccounts := sets.NewString(), sets.NewString()
+	inUse := func(namespace string, annotations map[string]string) {
+		if name, ok := annotations[SecretAnnotation]; ok {
+			secrets.Insert(namespace + "/" + name)
+		}
+		if name, ok := annotations[ServiceAccountAnnotation]; ok {
+			serviceAccounts.Insert(namespace + "/" + name)
+		}
+	}
+
+	kinds := []collectable{{
+		kind: "PipelineRun",
+		list: func(ctx context.Context, namespace string, lo metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+			l, err := client.TektonV1beta1().PipelineRuns(namespace).List(ctx, lo)
+			if err != nil {
+				return nil, err
+			}
+			metas := make([]metav1.ObjectMeta, 0, len(l.Items))
+			for _, o := range l.Items {
+				// Leave PipelineRuns that are still running alone, however old they are.
+				if !o.IsDone() {
+					if ps := o.Spec.PipelineSpec; ps != nil {
+						for _, task := range ps.Tasks {
+							if task.TaskSpec != nil {
+								inUse(o.Namespace, task.TaskSpec.Metadata.Annotations)
+							}
+						}
+					}
+					continue
+				}
+				metas = append(metas, o.ObjectMeta)
+			}
+			return metas, nil
+		},
+		delete: func(ctx context.Context, namespace, name string) error {
+			return client.TektonV1beta1().PipelineRuns(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	}, {
+		kind: "TaskRun",
+		list: func(ctx context.Context, namespace string, lo metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+			l, err := client.TektonV1beta1().TaskRuns(namespace).List(ctx, lo)
+			if err != nil {
+				return nil, err
+			}
+			metas := make([]metav1.ObjectMeta, 0, len(l.Items))
+			for _, o := range l.Items {
+				// Leave TaskRuns that are still running alone, however old they are.
+				if !o.IsDone() {
+					inUse(o.Namespace, o.Annotations)
+					continue
+				}
+				metas = append(metas, o.ObjectMeta)
+			}
+			return metas, nil
+		},
+		delete: func(ctx context.Context, namespace, name string) error {
+			return client.TektonV1beta1().TaskRuns(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	}, {
+		kind: "Pod",
+		list: func(ctx context.Context, namespace string, lo metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+			l, err := kubeclient.CoreV1().Pods(namespace).List(ctx, lo)
+			if err != nil {
+				return nil, err
+			}
+			metas := make([]metav1.ObjectMeta, 0, len(l.Items))
+			for _, o := range l.Items {
+				metas = append(metas, o.ObjectMeta)
+			}
+			return metas, nil
+		},
+		delete: func(ctx context.Context, namespace, name string) error {
+			return kubeclient.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	}, {
+		kind: "Secret",
+		list: func(ctx context.Context, namespace string, lo metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+			l, err := kubeclient.CoreV1().Secrets(namespace).List(ctx, lo)
+			if err != nil {
+				return nil, err
+			}
+			metas := make([]metav1.ObjectMeta, 0, len(l.Items))
+			for _, o := range l.Items {
+				if secrets.Has(o.Namespace + "/" + o.Name) {
+					continue
+				}
+				metas = append(metas, o.ObjectMeta)
+			}
+			return metas, nil
+		},
+		delete: func(ctx context.Context, namespace, name string) error {
+			return kubeclient.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	}, {
+		kind: "ServiceAccount",
+		list: func(ctx context.Context, namespace string, lo metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+			l, err := kubeclient.CoreV1().ServiceAccounts(namespace).List(ctx, lo)
+			if err != nil {
+				return nil, err
+			}
+			metas := make([]metav1.ObjectMeta, 0, len(l.Items))
+			for _, o := range l.Items {
+				if serviceAccounts.Has(o.Namespace + "/" + o.Name) {
+					continue
+				}
+				metas = append(metas, o.ObjectMeta)
+			}
+			return metas, nil
+		},
+		delete: func(ctx context.Context, namespace, name string) error {
+			return kubeclient.CoreV1().ServiceAccounts(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	}}
+
+	selector := metav1.ListOptions{LabelSelector: ManagedByLabel + "=" + ManagedBy}
+	for _, k := range kinds {
+		metas, err := k.list(ctx, opts.Namespace, selector)
+		if err != nil {
+			return fmt.Errorf("failed to list %ss: %w", k.kind, err)
+		}
+		for _, meta := range metas {
+			if !expired(meta, opts.OlderThan, opts.Now) {
+				continue
+			}
+			if opts.DryRun {
+				fmt.Fprintf(opts.Out, "would delete %s %s/%s\n", k.kind, meta.Namespace, meta.Name)
+				continue
+			}
+			// Deleting owners may have already deleted this object.
+			if err := k.delete(ctx, meta.Namespace, meta.Name); err != nil && !apierrs.IsNotFound(err) {
+				return fmt.Errorf("failed to delete %s %s/%s: %w", k.kind, meta.Namespace, meta.Name, err)
+			}
+			fmt.Fprintf(opts.Out, "deleted %s %s/%s\n", k.kind, meta.Namespace, meta.Name)
+		}
+	}
+	return nil
+}
+
+// expired checks whether the object is older than olderThan, or has outlived the TTL in
+// its annotations, as of now.  Owned objects are left to Kubernetes to garbage collect
+// along with their owners.
+func expired(meta metav1.ObjectMeta, olderThan time.Duration, now time.Time) bool {
+	if len(meta.OwnerReferences) > 0 {
+		return false
+	}
+	if ttl, err := time.ParseDuration(meta.Annotations[TTLAnnotation]); err == nil && ttl < olderThan {
+		olderThan = ttl
+	}
+	return meta.CreationTimestamp.Add(olderThan).Before(now)
+}
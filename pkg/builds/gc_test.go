@@ -0,0 +1,155 @@
+package builds_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	tektonfake "github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"knative.dev/pkg/apis"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	now := time.Now()
+	meta := func(name string, age time.Duration) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(now.Add(-age)),
+			Labels:            map[string]string{builds.ManagedByLabel: builds.ManagedBy},
+		}
+	}
+	owned := meta("owned", 2*time.Hour)
+	owned.OwnerReferences = []metav1.OwnerReference{{Kind: "TaskRun", Name: "owner"}}
+	expiring := meta("expiring", 10*time.Minute)
+	expiring.Annotations = map[string]string{builds.TTLAnnotation: "5m"}
+	unlabelled := meta("unlabelled", 2*time.Hour)
+	unlabelled.Labels = nil
+	done := func(meta metav1.ObjectMeta) *tknv1beta1.TaskRun {
+		tr := &tknv1beta1.TaskRun{ObjectMeta: meta}
+		tr.Status.SetCondition(&apis.Condition{
+			Type:   apis.ConditionSucceeded,
+			Status: corev1.ConditionTrue,
+		})
+		return tr
+	}
+
+	client := tektonfake.NewSimpleClientset(
+		done(meta("old", 2*time.Hour)),
+		done(meta("new", time.Minute)),
+		done(unlabelled),
+		&tknv1beta1.TaskRun{ObjectMeta: meta("running", 2*time.Hour)},
+		&tknv1beta1.PipelineRun{ObjectMeta: meta("running", 2*time.Hour)},
+	)
+	kubeclient := fake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: owned},
+		&corev1.Secret{ObjectMeta: expiring},
+		&corev1.ServiceAccount{ObjectMeta: meta("old", 2*time.Hour)},
+	)
+
+	var out bytes.Buffer
+	opts := builds.GCOptions{
+		OlderThan: time.Hour,
+		DryRun:    true,
+		Now:       now,
+		Out:       &out,
+	}
+	require.NoError(t, builds.GarbageCollect(context.TODO(), client, kubeclient, opts))
+	assert.Equal(t, "would delete TaskRun default/old\n"+
+		"would delete Secret default/expiring\n"+
+		"would delete ServiceAccount default/old\n", out.String())
+
+	trs, err := client.TektonV1beta1().TaskRuns("default").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, trs.Items, 4, "a dry-run should not delete anything")
+
+	out.Reset()
+	opts.DryRun = false
+	require.NoError(t, builds.GarbageCollect(context.TODO(), client, kubeclient, opts))
+	assert.Equal(t, "deleted TaskRun default/old\n"+
+		"deleted Secret default/expiring\n"+
+		"deleted ServiceAccount default/old\n", out.String())
+
+	trs, err = client.TektonV1beta1().TaskRuns("default").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	var names []string
+	for _, tr := range trs.Items {
+		names = append(names, tr.Name)
+	}
+	assert.ElementsMatch(t, []string{"new", "unlabelled", "running"}, names, "running TaskRuns are left alone")
+
+	prs, err := client.TektonV1beta1().PipelineRuns("default").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, prs.Items, 1, "running PipelineRuns are left alone")
+
+	pods, err := kubeclient.CoreV1().Pods("default").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, pods.Items, 1, "owned pods are left to their owners")
+}
+
+func TestGarbageCollectRunningCredentials(t *testing.T) {
+	now := time.Now()
+	meta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour)),
+			Labels:            map[string]string{builds.ManagedByLabel: builds.ManagedBy},
+			Annotations:       map[string]string{builds.TTLAnnotation: "1h"},
+		}
+	}
+	credentials := map[string]string{
+		builds.SecretAnnotation:         "taskrun-creds",
+		builds.ServiceAccountAnnotation: "taskrun-creds",
+	}
+
+	// Neither run managed to adopt its credentials.
+	tr := &tknv1beta1.TaskRun{ObjectMeta: meta("running")}
+	tr.Annotations = credentials
+	pr := &tknv1beta1.PipelineRun{
+		ObjectMeta: meta("running"),
+		Spec: tknv1beta1.PipelineRunSpec{
+			PipelineSpec: &tknv1beta1.PipelineSpec{
+				Tasks: []tknv1beta1.PipelineTask{{
+					Name: "build",
+					TaskSpec: &tknv1beta1.EmbeddedTask{
+						Metadata: tknv1beta1.PipelineTaskMetadata{
+							Annotations: map[string]string{
+								builds.SecretAnnotation:         "pipelinerun-creds",
+								builds.ServiceAccountAnnotation: "pipelinerun-creds",
+							},
+						},
+					},
+				}},
+			},
+		},
+	}
+	client := tektonfake.NewSimpleClientset(tr, pr)
+	kubeclient := fake.NewSimpleClientset(
+		&corev1.Secret{ObjectMeta: meta("taskrun-creds")},
+		&corev1.Secret{ObjectMeta: meta("pipelinerun-creds")},
+		&corev1.Secret{ObjectMeta: meta("stale")},
+		&corev1.ServiceAccount{ObjectMeta: meta("taskrun-creds")},
+		&corev1.ServiceAccount{ObjectMeta: meta("pipelinerun-creds")},
+		&corev1.ServiceAccount{ObjectMeta: meta("stale")},
+	)
+
+	var out bytes.Buffer
+	opts := builds.GCOptions{
+		OlderThan: 24 * time.Hour,
+		Now:       now,
+		Out:       &out,
+	}
+	require.NoError(t, builds.GarbageCollect(context.TODO(), client, kubeclient, opts))
+	assert.Equal(t, "deleted Secret default/stale\n"+
+		"deleted ServiceAccount default/stale\n", out.String(),
+		"the credentials of running builds are left alone")
+}
@@ -19,9 +19,11 @@ package ko
 import (
 	"context"
 	"fmt"
+	"path"
 	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
 	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -29,24 +31,231 @@ import (
 	"knative.dev/pkg/ptr"
 )
 
+const (
+	// StepName is the name of the step that runs ko publish.
+	StepName = "ko-publish"
+
+	// NamingBare, NamingBaseImportPaths and NamingPreserveImportPaths are ko's
+	// strategies for naming the images it publishes under KO_DOCKER_REPO: as it
+	// is, suffixed with the last element of the import path, or suffixed with
+	// the whole import path.
+	NamingBare                = "bare"
+	NamingBaseImportPaths     = "base-import-paths"
+	NamingPreserveImportPaths = "preserve-import-paths"
+
+	// CacheDir is where the Go build and module caches are mounted, when
+	// they are kept across builds.
+	CacheDir = "/go-cache"
+
+	restoreCacheStepName = "restore-go-cache"
+	saveCacheStepName    = "save-go-cache"
+	cacheVolumeName      = "go-cache"
+)
+
+// Namings are ko's strategies for naming the images it publishes.
+var Namings = []string{NamingBare, NamingBaseImportPaths, NamingPreserveImportPaths}
+
 // Options holds configuration options specific to Dockerfile builds
 type Options struct {
 	// ImportPath is the path to ko publish
 	ImportPath string
+
+	// Naming is the strategy (one of Namings) with which ko names the image
+	// under the target's repository, which defaults to NamingBare.
+	Naming string
+
+	// BaseImage overrides the default base image of ko (and of the .ko.yaml
+	// in the build context), but not its per import path overrides.
+	BaseImage string
+
+	// KoBinary is the ko binary to invoke, which defaults to "ko" on the PATH.
+	KoBinary string
+
+	// Platforms are the platforms (e.g. linux/arm64) for which to build the image,
+	// which ko assembles into an image index when there are several of them.
+	Platforms []string
+
+	// CachePVC is the name of a PersistentVolumeClaim holding the Go build and
+	// module caches, so that they persist across builds.
+	CachePVC string
+
+	// CacheImage is an image in which to keep the Go build and module caches
+	// across builds, which are restored from it before the build and saved to
+	// it afterwards.
+	CacheImage string
+
+	// GoProxy and GoFlags are passed to the go toolchain as GOPROXY and GOFLAGS.
+	GoProxy string
+	GoFlags string
 }
 
 var (
 	// KoImageString holds a reference to a built image of github.com/google/ko
 	// See ./hack/build-flags.sh for how this is replaced at link-time.
 	KoImageString = "docker.io/mattmoor/ko:latest"
+
+	// CraneImage is the image with which we restore and save the Go caches
+	// when they are kept in an image.
+	CraneImage = "gcr.io/go-containerregistry/crane:debug"
 )
 
+// Target returns where ko publishes the import path of the options, when using
+// the repository of the target tag as its KO_DOCKER_REPO.
+func Target(target name.Tag, opt Options) (name.Tag, error) {
+	importPath := strings.TrimPrefix(opt.ImportPath, "ko://")
+	repo := target.Context().String()
+	switch opt.Naming {
+	case "", NamingBare:
+		return target, nil
+	case NamingBaseImportPaths:
+		repo = path.Join(repo, path.Base(importPath))
+	case NamingPreserveImportPaths:
+		repo = path.Join(repo, importPath)
+	default:
+		return name.Tag{}, fmt.Errorf("unknown ko naming strategy %q, expected one of %v", opt.Naming, Namings)
+	}
+	return name.NewTag(repo+":"+target.TagStr(), name.WeakValidation)
+}
+
 // Build returns a TaskRun suitable for performing a "ko publish" build over the
-// provided kontext and publishing to the target tag.
+// provided kontext and publishing under the repository of the target tag (see
+// Target).  ko reads the .ko.yaml at the root of the build context, and so its
+// base images and the ldflags and flags of its builds apply.
 func Build(ctx context.Context, sourceSteps []tknv1beta1.Step, target name.Tag, opt Options) *tknv1beta1.TaskRun {
+	koBinary := opt.KoBinary
+	if koBinary == "" {
+		koBinary = "ko"
+	}
+	naming := opt.Naming
+	if naming == "" {
+		naming = NamingBare
+	}
+	publish := koBinary + " publish --" + naming
+	if len(opt.Platforms) > 0 {
+		publish += " --platform=" + strings.Join(opt.Platforms, ",")
+	}
+	env := []corev1.EnvVar{{
+		Name:  "DOCKER_CONFIG",
+		Value: "/tekton/home/.docker",
+	}, {
+		Name:  "KO_DOCKER_REPO",
+		Value: target.Repository.String(),
+	}, {
+		Name:  "KO_CONFIG_PATH",
+		Value: builds.WorkspaceDir,
+	}}
+	if opt.BaseImage != "" {
+		env = append(env, corev1.EnvVar{
+			Name:  "KO_DEFAULTBASEIMAGE",
+			Value: opt.BaseImage,
+		})
+	}
+	if opt.GoProxy != "" {
+		env = append(env, corev1.EnvVar{
+			Name:  "GOPROXY",
+			Value: opt.GoProxy,
+		})
+	}
+	if opt.GoFlags != "" {
+		env = append(env, corev1.EnvVar{
+			Name:  "GOFLAGS",
+			Value: opt.GoFlags,
+		})
+	}
+
+	step := tknv1beta1.Step{
+		Container: corev1.Container{
+			Name:       StepName,
+			Image:      KoImageString,
+			WorkingDir: builds.WorkspaceDir,
+			Env:        env,
+			Command: []string{
+				"/bin/bash", "-c",
+			},
+			Args: []string{
+				strings.Join([]string{
+					// Good for debugging.
+					"go env",
+					// Not set for some reason :rolls_eyes:
+					"export GOARCH=$(go env GOARCH)",
+					"export GOOS=$(go env GOOS)",
+					"export GOARM=$(go env GOARM)",
+					"export GOROOT=$(go env GOROOT)",
+					// Where the magic happens.
+					fmt.Sprintf("%s %s | cut -d'@' -f 2 > /tekton/results/IMAGE-DIGEST", publish, opt.ImportPath),
+				}, " && "),
+			},
+			Resources: corev1.ResourceRequirements{
+				// Set requests based on a typical ko task,
+				// but do not set limits because it could
+				// go well beyond this (in theory).
+				Requests: corev1.ResourceList{
+					// 1 is typical, but I've seen up to 2.
+					"cpu": resource.MustParse("1"),
+					// 500-700Mi was typical.
+					"memory": resource.MustParse("1Gi"),
+				},
+			},
+		},
+	}
+	steps := append([]tknv1beta1.Step{}, sourceSteps...)
+	var volumes []corev1.Volume
+
+	if opt.CachePVC != "" || opt.CacheImage != "" {
+		cache := corev1.VolumeMount{
+			Name:      cacheVolumeName,
+			MountPath: CacheDir,
+		}
+		step.VolumeMounts = append(step.VolumeMounts, cache)
+		step.Env = append(step.Env, corev1.EnvVar{
+			Name:  "GOMODCACHE",
+			Value: CacheDir + "/mod",
+		}, corev1.EnvVar{
+			Name:  "GOCACHE",
+			Value: CacheDir + "/build",
+		})
+
+		if opt.CachePVC != "" {
+			volumes = append(volumes, corev1.Volume{
+				Name: cacheVolumeName,
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: opt.CachePVC,
+					},
+				},
+			})
+			steps = append(steps, step)
+		} else {
+			volumes = append(volumes, corev1.Volume{
+				Name: cacheVolumeName,
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{},
+				},
+			})
+			// Restore the caches from the image before the build, and save them after.
+			image := shellQuote(opt.CacheImage)
+			steps = append(steps, craneStep(restoreCacheStepName, cache, strings.Join([]string{
+				fmt.Sprintf("crane export %s - | tar -xf - -C %s", image, CacheDir),
+				fmt.Sprintf("echo no Go caches restored from %s", image),
+			}, " || ")), step, craneStep(saveCacheStepName, cache, strings.Join([]string{
+				fmt.Sprintf("tar -cf /tekton/home/go-cache.tar -C %s .", CacheDir),
+				fmt.Sprintf("crane append -f /tekton/home/go-cache.tar -t %s", image),
+			}, " && ")))
+		}
+	} else {
+		steps = append(steps, step)
+	}
+
 	return &tknv1beta1.TaskRun{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: "ko-publish-",
+			Labels: map[string]string{
+				builds.ManagedByLabel: builds.ManagedBy,
+				builds.BuilderLabel:   "ko",
+			},
+			Annotations: map[string]string{
+				builds.ImageAnnotation: target.String(),
+			},
 		},
 		Spec: tknv1beta1.TaskRunSpec{
 			PodTemplate: &tknv1beta1.PodTemplate{
@@ -58,47 +267,32 @@ func Build(ctx context.Context, sourceSteps []tknv1beta1.Step, target name.Tag,
 					Name: "IMAGE-DIGEST",
 				}},
 
-				Steps: append(sourceSteps, tknv1beta1.Step{
-					Container: corev1.Container{
-						Name:  "ko-publish",
-						Image: KoImageString,
-						Env: []corev1.EnvVar{{
-							Name:  "DOCKER_CONFIG",
-							Value: "/tekton/home/.docker",
-						}, {
-							Name:  "KO_DOCKER_REPO",
-							Value: target.Repository.String(),
-						}},
-						Command: []string{
-							"/bin/bash", "-c",
-						},
-						Args: []string{
-							strings.Join([]string{
-								// Good for debugging.
-								"go env",
-								// Not set for some reason :rolls_eyes:
-								"export GOARCH=$(go env GOARCH)",
-								"export GOOS=$(go env GOOS)",
-								"export GOARM=$(go env GOARM)",
-								"export GOROOT=$(go env GOROOT)",
-								// Where the magic happens.
-								fmt.Sprintf("ko publish --bare %s | cut -d'@' -f 2 > /tekton/results/IMAGE-DIGEST", opt.ImportPath),
-							}, " && "),
-						},
-						Resources: corev1.ResourceRequirements{
-							// Set requests based on a typical ko task,
-							// but do not set limits because it could
-							// go well beyond this (in theory).
-							Requests: corev1.ResourceList{
-								// 1 is typical, but I've seen up to 2.
-								"cpu": resource.MustParse("1"),
-								// 500-700Mi was typical.
-								"memory": resource.MustParse("1Gi"),
-							},
-						},
-					},
-				}),
+				Steps:   steps,
+				Volumes: volumes,
 			},
 		},
 	}
 }
+
+// craneStep returns a step that runs the shell command with crane, with the
+// Go caches mounted.
+func craneStep(name string, cache corev1.VolumeMount, command string) tknv1beta1.Step {
+	return tknv1beta1.Step{
+		Container: corev1.Container{
+			Name:    name,
+			Image:   CraneImage,
+			Command: []string{"/busybox/sh", "-c"},
+			Args:    []string{command},
+			Env: []corev1.EnvVar{{
+				Name:  "DOCKER_CONFIG",
+				Value: "/tekton/home/.docker",
+			}},
+			VolumeMounts: []corev1.VolumeMount{cache},
+		},
+	}
+}
+
+// shellQuote quotes the string for use as a single word in a shell script.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
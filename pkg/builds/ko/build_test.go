@@ -0,0 +1,92 @@
+package ko_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds/ko"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestTarget(t *testing.T) {
+	target, err := name.NewTag("gcr.io/foo/bar:v1")
+	require.NoError(t, err)
+
+	for naming, want := range map[string]string{
+		"":                           "gcr.io/foo/bar:v1",
+		ko.NamingBare:                "gcr.io/foo/bar:v1",
+		ko.NamingBaseImportPaths:     "gcr.io/foo/bar/baz:v1",
+		ko.NamingPreserveImportPaths: "gcr.io/foo/bar/github.com/foo/baz:v1",
+	} {
+		got, err := ko.Target(target, ko.Options{
+			ImportPath: "ko://github.com/foo/baz",
+			Naming:     naming,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, want, got.String(), naming)
+	}
+
+	_, err = ko.Target(target, ko.Options{Naming: "md5"})
+	assert.Error(t, err, "unknown naming strategy")
+}
+
+func TestBuild(t *testing.T) {
+	target, err := name.NewTag("gcr.io/foo/bar:v1")
+	require.NoError(t, err)
+
+	tr := ko.Build(context.TODO(), nil, target, ko.Options{
+		ImportPath: "ko://github.com/foo/baz",
+		Naming:     ko.NamingBaseImportPaths,
+		BaseImage:  "gcr.io/distroless/base",
+	})
+	step := tr.Spec.TaskSpec.Steps[0]
+	assert.Contains(t, step.Args[0], "ko publish --base-import-paths ko://github.com/foo/baz")
+	assert.Subset(t, step.Env, []corev1.EnvVar{
+		{Name: "KO_DOCKER_REPO", Value: "gcr.io/foo/bar"},
+		{Name: "KO_CONFIG_PATH", Value: "/workspace"},
+		{Name: "KO_DEFAULTBASEIMAGE", Value: "gcr.io/distroless/base"},
+	})
+}
+
+func TestBuildCache(t *testing.T) {
+	target, err := name.NewTag("gcr.io/foo/bar:v1")
+	require.NoError(t, err)
+
+	stepNames := func(steps []tknv1beta1.Step) []string {
+		var names []string
+		for _, step := range steps {
+			names = append(names, step.Name)
+		}
+		return names
+	}
+
+	tr := ko.Build(context.TODO(), nil, target, ko.Options{
+		ImportPath: "ko://github.com/foo/baz",
+		CachePVC:   "go-cache",
+		GoProxy:    "https://proxy.golang.org",
+	})
+	steps := tr.Spec.TaskSpec.Steps
+	assert.Equal(t, []string{ko.StepName}, stepNames(steps))
+	assert.Subset(t, steps[0].Env, []corev1.EnvVar{
+		{Name: "GOPROXY", Value: "https://proxy.golang.org"},
+		{Name: "GOMODCACHE", Value: ko.CacheDir + "/mod"},
+		{Name: "GOCACHE", Value: ko.CacheDir + "/build"},
+	})
+	require.Len(t, tr.Spec.TaskSpec.Volumes, 1)
+	assert.Equal(t, "go-cache", tr.Spec.TaskSpec.Volumes[0].PersistentVolumeClaim.ClaimName)
+
+	tr = ko.Build(context.TODO(), nil, target, ko.Options{
+		ImportPath: "ko://github.com/foo/baz",
+		CacheImage: "gcr.io/foo/go-cache",
+	})
+	steps = tr.Spec.TaskSpec.Steps
+	assert.Equal(t, []string{"restore-go-cache", ko.StepName, "save-go-cache"}, stepNames(steps))
+	assert.Contains(t, steps[0].Args[0], "crane export 'gcr.io/foo/go-cache'")
+	assert.Contains(t, steps[2].Args[0], "crane append")
+	require.Len(t, tr.Spec.TaskSpec.Volumes, 1)
+	assert.NotNil(t, tr.Spec.TaskSpec.Volumes[0].EmptyDir)
+}
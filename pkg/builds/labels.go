@@ -0,0 +1,117 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// ManagedByLabel is the label with which we mark everything we create in the
+	// cluster (and which Tekton propagates to the pods of our builds), so that it
+	// can be found by `mink gc` should it be leaked.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+
+	// ManagedBy is the value of the ManagedByLabel.
+	ManagedBy = "mink"
+)
+
+const (
+	// BuilderLabel holds the type of builder (e.g. dockerfile) that produced a TaskRun.
+	BuilderLabel = "mink.dev/builder"
+
+	// UserLabel holds the (sanitized) name of the user that invoked mink, the
+	// UserAnnotation holds it verbatim.
+	UserLabel      = "mink.dev/user"
+	UserAnnotation = "mink.dev/user"
+
+	// GitRepositoryLabel holds the (sanitized) host and path of the git repository
+	// from which the source was taken, the GitURLAnnotation holds its URL verbatim.
+	GitRepositoryLabel = "mink.dev/git-repository"
+	GitURLAnnotation   = "mink.dev/git-url"
+
+	// GitRevisionLabel holds the (sanitized) git revision from which the source was
+	// taken, the GitRevisionAnnotation holds it verbatim.
+	GitRevisionLabel      = "mink.dev/git-revision"
+	GitRevisionAnnotation = "mink.dev/git-revision"
+
+	// ImageAnnotation holds the image that a TaskRun publishes.
+	ImageAnnotation = "mink.dev/image"
+
+	// NoImageAnnotation marks TaskRuns that do not publish their image (e.g. those
+	// that only report how it would be built), which yield an empty digest.
+	NoImageAnnotation = "mink.dev/no-image"
+)
+
+var invalidLabelChars = regexp.MustCompile("[^a-zA-Z0-9_.-]+")
+
+// LabelValue sanitizes the provided string into a valid label value, by replacing
+// any characters that are not allowed with dashes and truncating it.
+func LabelValue(s string) string {
+	s = invalidLabelChars.ReplaceAllString(s, "-")
+	if len(s) > validation.LabelValueMaxLength {
+		s = s[:validation.LabelValueMaxLength]
+	}
+	return strings.TrimFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// WithMetadata adds the provided labels and annotations to the TaskRun, and so to
+// everything created in support of it (options that create objects should follow it).
+func WithMetadata(labels, annotations map[string]string) CancelableOption {
+	return func(ctx context.Context, tr *tknv1beta1.TaskRun) (context.CancelFunc, error) {
+		if len(labels) > 0 && tr.Labels == nil {
+			tr.Labels = make(map[string]string, len(labels))
+		}
+		for k, v := range labels {
+			tr.Labels[k] = v
+		}
+		if len(annotations) > 0 && tr.Annotations == nil {
+			tr.Annotations = make(map[string]string, len(annotations))
+		}
+		for k, v := range annotations {
+			tr.Annotations[k] = v
+		}
+		return func() {}, nil
+	}
+}
+
+// commonMetadata returns the entries that all of the provided maps have in common.
+func commonMetadata(maps ...map[string]string) map[string]string {
+	if len(maps) == 0 {
+		return nil
+	}
+	common := make(map[string]string, len(maps[0]))
+	for k, v := range maps[0] {
+		common[k] = v
+	}
+	for _, m := range maps[1:] {
+		for k, v := range common {
+			if mv, ok := m[k]; !ok || mv != v {
+				delete(common, k)
+			}
+		}
+	}
+	return common
+}
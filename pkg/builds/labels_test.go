@@ -0,0 +1,15 @@
+package builds_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelValue(t *testing.T) {
+	assert.Equal(t, "github.com-jenkins-x-labs-bdd-mink", builds.LabelValue("github.com/jenkins-x-labs-bdd/mink"))
+	assert.Equal(t, "jane.doe", builds.LabelValue("@jane.doe@"))
+	assert.Len(t, builds.LabelValue(strings.Repeat("a", 100)), 63)
+}
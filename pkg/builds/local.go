@@ -0,0 +1,315 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	errs "github.com/pkg/errors"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// WorkspaceDir is where the source is expanded within the TaskRuns we produce.
+	WorkspaceDir = "/workspace"
+
+	// ResultsDir is where Tekton expects steps to write their results.
+	ResultsDir = "/tekton/results"
+
+	// HomeDir is the home directory Tekton shares between steps.
+	HomeDir = "/tekton/home"
+)
+
+// LocalStep is an in-process implementation of a step, for steps whose image
+// is not available when executing locally.  The step it is passed has had its
+// paths rewritten onto their local equivalents.
+type LocalStep func(ctx context.Context, step tknv1beta1.Step) error
+
+// LocalExecutor executes builds by running each of the TaskRun's steps as a
+// local process, in sequence.  This is intended for use when mink is itself
+// running inside of a container (e.g. a Pipeline step) that has the tooling
+// for the build available, so that we avoid chaining a second TaskRun.
+type LocalExecutor struct {
+	// Workspace is the local directory holding the source, which stands in
+	// for /workspace within the steps.
+	Workspace string
+
+	// SkipSteps holds the names of steps that should not be run locally,
+	// typically the source steps since the source is already available
+	// in the Workspace.
+	SkipSteps sets.String
+
+	// Binaries maps the names of steps to the local binary to invoke in
+	// place of the step's command (or the image's entrypoint).
+	Binaries map[string]string
+
+	// Steps maps the names of steps to in-process implementations, which
+	// are run in place of the step.
+	Steps map[string]LocalStep
+
+	// Stdout and Stderr are where the output of the steps is sent.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// LocalExecutor implements Executor
+var _ Executor = (*LocalExecutor)(nil)
+
+// Execute implements Executor
+func (le *LocalExecutor) Execute(ctx context.Context, image string, tr *tknv1beta1.TaskRun) (name.Digest, error) {
+	if tr.Spec.TaskSpec == nil {
+		return name.Digest{}, fmt.Errorf("TaskRun %s has no inline TaskSpec to execute", tr.GenerateName)
+	}
+
+	workspace, err := filepath.Abs(le.Workspace)
+	if err != nil {
+		return name.Digest{}, errs.Wrapf(err, "failed to find absolute path for %s", le.Workspace)
+	}
+
+	// lets make sure we have a tmp dir as it may not exist yet if inside, say, a kaniko image
+	if err := os.MkdirAll(os.TempDir(), 0760); err != nil {
+		return name.Digest{}, errs.Wrapf(err, "failed to create temp dir %s", os.TempDir())
+	}
+	tmpDir, err := ioutil.TempDir("", "mink-local-")
+	if err != nil {
+		return name.Digest{}, errs.Wrap(err, "failed to create temp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	resultsDir := filepath.Join(tmpDir, "results")
+	homeDir := filepath.Join(tmpDir, "home")
+	dirs := map[string]string{
+		WorkspaceDir: workspace,
+		ResultsDir:   resultsDir,
+		HomeDir:      homeDir,
+	}
+
+	// emptyDir volumes are shared between the steps via a local directory.
+	emptyDirs := make(map[string]string, len(tr.Spec.TaskSpec.Volumes))
+	for _, v := range tr.Spec.TaskSpec.Volumes {
+		if v.EmptyDir != nil {
+			emptyDirs[v.Name] = filepath.Join(tmpDir, "volumes", v.Name)
+		}
+	}
+	for _, step := range tr.Spec.TaskSpec.Steps {
+		for _, vm := range step.VolumeMounts {
+			if dir, ok := emptyDirs[vm.Name]; ok {
+				dirs[vm.MountPath] = dir
+			}
+		}
+	}
+
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0760); err != nil {
+			return name.Digest{}, errs.Wrapf(err, "failed to create dir %s", dir)
+		}
+	}
+	// Rewrite the paths the steps expect Tekton to provide onto their local equivalents.
+	paths := newPathReplacer(dirs)
+
+	for i, step := range tr.Spec.TaskSpec.Steps {
+		if le.SkipSteps.Has(step.Name) {
+			continue
+		}
+		step = localize(step, paths)
+		if fn, ok := le.Steps[step.Name]; ok {
+			log.Printf("running step %s in-process\n", step.Name)
+			if err := fn(ctx, step); err != nil {
+				return name.Digest{}, errs.Wrapf(err, "failed to run step %s", step.Name)
+			}
+			continue
+		}
+		if err := le.runStep(ctx, step, workspace, filepath.Join(tmpDir, fmt.Sprint("script-", i))); err != nil {
+			return name.Digest{}, err
+		}
+	}
+
+	if !producesDigest(tr) {
+		return name.Digest{}, nil
+	}
+	digestFile := filepath.Join(resultsDir, DigestResult)
+	data, err := ioutil.ReadFile(digestFile)
+	if err != nil {
+		return name.Digest{}, errs.Wrapf(err, "failed to read %s", digestFile)
+	}
+	value := strings.TrimSpace(string(data))
+	return name.NewDigest(image + "@" + value)
+}
+
+// pathReplacer rewrites the paths within (or beneath) a set of mount paths onto
+// other directories.  Unlike a strings.Replacer, it only rewrites whole paths, so
+// that e.g. /cache is rewritten in /cache/go and --cache-dir=/cache, but not in
+// gcr.io/foo/cache or /cache2.
+type pathReplacer struct {
+	// mountPaths are matched longest first.
+	mountPaths []string
+	dirs       map[string]string
+}
+
+// newPathReplacer returns a pathReplacer rewriting each of the keys of dirs onto
+// its value.
+func newPathReplacer(dirs map[string]string) *pathReplacer {
+	mountPaths := make([]string, 0, len(dirs))
+	for mountPath := range dirs {
+		mountPaths = append(mountPaths, mountPath)
+	}
+	sort.Slice(mountPaths, func(i, j int) bool {
+		return len(mountPaths[i]) > len(mountPaths[j])
+	})
+	return &pathReplacer{mountPaths: mountPaths, dirs: dirs}
+}
+
+// Replace returns a copy of s with the paths rewritten.  A mount path matches where
+// it starts a path, i.e. at the start of s, after a character that cannot be part of
+// a path (e.g. the = of --flag=/path, or a space in a script), or after a URL's
+// scheme (e.g. dir:///workspace), and where it ends a path component.
+func (pr *pathReplacer) Replace(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		mountPath := pr.match(s, i)
+		if mountPath == "" {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		b.WriteString(pr.dirs[mountPath])
+		i += len(mountPath)
+	}
+	return b.String()
+}
+
+// match returns the mount path starting a path at s[i:], if any.
+func (pr *pathReplacer) match(s string, i int) string {
+	if i > 0 && isPathChar(s[i-1]) && !strings.HasSuffix(s[:i], "://") {
+		return ""
+	}
+	for _, mountPath := range pr.mountPaths {
+		if !strings.HasPrefix(s[i:], mountPath) {
+			continue
+		}
+		if end := i + len(mountPath); end == len(s) || s[end] == '/' || !isPathChar(s[end]) {
+			return mountPath
+		}
+	}
+	return ""
+}
+
+// isPathChar returns whether c may be part of a path (or a path component).
+func isPathChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	default:
+		return strings.IndexByte("/._-~", c) >= 0
+	}
+}
+
+// localize returns a copy of the step with its paths rewritten onto their local equivalents.
+func localize(step tknv1beta1.Step, paths *pathReplacer) tknv1beta1.Step {
+	replaceAll := func(in []string) []string {
+		if in == nil {
+			return nil
+		}
+		out := make([]string, 0, len(in))
+		for _, s := range in {
+			out = append(out, paths.Replace(s))
+		}
+		return out
+	}
+
+	step = *step.DeepCopy()
+	step.Command = replaceAll(step.Command)
+	step.Args = replaceAll(step.Args)
+	step.Script = paths.Replace(step.Script)
+	step.WorkingDir = paths.Replace(step.WorkingDir)
+	for i := range step.Env {
+		step.Env[i].Value = paths.Replace(step.Env[i].Value)
+	}
+	for i := range step.VolumeMounts {
+		step.VolumeMounts[i].MountPath = paths.Replace(step.VolumeMounts[i].MountPath)
+	}
+	return step
+}
+
+func (le *LocalExecutor) runStep(ctx context.Context, step tknv1beta1.Step, workspace, scriptFile string) error {
+	var argv []string
+	switch binary, ok := le.Binaries[step.Name]; {
+	case ok:
+		// Resolve relative binaries against our directory rather than the step's.
+		if strings.ContainsRune(binary, filepath.Separator) {
+			abs, err := filepath.Abs(binary)
+			if err != nil {
+				return errs.Wrapf(err, "failed to find absolute path for %s", binary)
+			}
+			binary = abs
+		}
+		argv = append([]string{binary}, step.Args...)
+	case step.Script != "":
+		if err := ioutil.WriteFile(scriptFile, []byte(step.Script), 0700); err != nil {
+			return errs.Wrapf(err, "failed to write script for step %s", step.Name)
+		}
+		argv = append([]string{scriptFile}, step.Args...)
+	case len(step.Command) > 0:
+		argv = append(append([]string{}, step.Command...), step.Args...)
+	default:
+		return fmt.Errorf("step %s has no command and no local binary was configured for it", step.Name)
+	}
+
+	dir := workspace
+	if step.WorkingDir != "" {
+		dir = step.WorkingDir
+	}
+	if err := os.MkdirAll(dir, 0760); err != nil {
+		return errs.Wrapf(err, "failed to create working dir %s", dir)
+	}
+
+	// Pass through our environment, augmented by the step's.
+	env := os.Environ()
+	for _, ev := range step.Env {
+		// The steps point DOCKER_CONFIG at the Tekton home directory, but locally
+		// we want the tooling to find the credentials of the current environment.
+		if ev.Name == "DOCKER_CONFIG" {
+			continue
+		}
+		env = append(env, ev.Name+"="+ev.Value)
+	}
+
+	argsText := strings.Join(argv, " ")
+	log.Printf("running step %s: %s\n", step.Name, argsText)
+
+	//nolint:gosec // Running the build steps is the point.
+	c := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	c.Dir = dir
+	c.Env = env
+	c.Stdout = le.Stdout
+	c.Stderr = le.Stderr
+	if err := c.Run(); err != nil {
+		return errs.Wrapf(err, "failed to run step %s: %s", step.Name, argsText)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package builds_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+var expectedDigest = "sha256:8e65ec4b80519d869e8d600fdf262c6e8cd3f6c7e8382406d9cb039f352a69bc"
+
+func TestLocalExecutor(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+
+	tr := &tknv1beta1.TaskRun{
+		Spec: tknv1beta1.TaskRunSpec{
+			TaskSpec: &tknv1beta1.TaskSpec{
+				Steps: []tknv1beta1.Step{{
+					Container: corev1.Container{
+						Name:  "extract-bundle",
+						Image: "does-not-exist",
+					},
+				}, {
+					Container: corev1.Container{
+						Name:    "write-source",
+						Command: []string{"/bin/sh", "-c"},
+						Args:    []string{"echo hello > /workspace/source.txt"},
+					},
+				}, {
+					Container: corev1.Container{
+						Name: "write-digest",
+						Env: []corev1.EnvVar{{
+							Name:  "DIGEST_FILE",
+							Value: "/tekton/results/IMAGE-DIGEST",
+						}},
+					},
+					Script: "#!/bin/sh\ntest -f /workspace/source.txt && echo " + expectedDigest + " > $DIGEST_FILE\n",
+				}},
+			},
+		},
+	}
+
+	le := &builds.LocalExecutor{
+		Workspace: tmpDir,
+		SkipSteps: sets.NewString("extract-bundle"),
+		Stdout:    ioutil.Discard,
+		Stderr:    ioutil.Discard,
+	}
+	digest, err := le.Execute(context.TODO(), "gcr.io/jenkins-x-labs-bdd/myimage:latest", tr)
+	require.NoError(t, err, "failed to execute TaskRun locally")
+
+	assert.Equal(t, "gcr.io/jenkins-x-labs-bdd/myimage:latest@"+expectedDigest, digest.String())
+	assert.FileExists(t, filepath.Join(tmpDir, "source.txt"))
+}
+
+func TestLocalExecutorMissingCommand(t *testing.T) {
+	tr := &tknv1beta1.TaskRun{
+		Spec: tknv1beta1.TaskRunSpec{
+			TaskSpec: &tknv1beta1.TaskSpec{
+				Steps: []tknv1beta1.Step{{
+					Container: corev1.Container{
+						Name:  "build-and-push",
+						Image: "gcr.io/kaniko-project/executor:multi-arch",
+					},
+				}},
+			},
+		},
+	}
+
+	le := &builds.LocalExecutor{Workspace: "."}
+	_, err := le.Execute(context.TODO(), "gcr.io/jenkins-x-labs-bdd/myimage:latest", tr)
+	require.Error(t, err, "expected an error for a step without a command")
+}
+
+func TestLocalExecutorPaths(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(tmpDir)
+
+	tr := &tknv1beta1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{builds.NoImageAnnotation: "true"},
+		},
+		Spec: tknv1beta1.TaskRunSpec{
+			TaskSpec: &tknv1beta1.TaskSpec{
+				Volumes: []corev1.Volume{{
+					Name:         "cache",
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+				}},
+				Steps: []tknv1beta1.Step{{
+					Container: corev1.Container{
+						Name: "build",
+						Args: []string{
+							"/workspace",
+							"/workspace/Dockerfile",
+							"--dockerfile=/workspace/Dockerfile",
+							"--context=dir:///workspace/",
+							"--cache-repo=gcr.io/foo/cache",
+							"/cache2",
+							"/foo/workspace",
+							"/workspace.bak",
+						},
+						WorkingDir: "/workspace/svc",
+						Env: []corev1.EnvVar{{
+							Name:  "GOCACHE",
+							Value: "/cache/go",
+						}, {
+							Name:  "IMAGE",
+							Value: "gcr.io/foo/cache:latest",
+						}},
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "cache",
+							MountPath: "/cache",
+						}},
+					},
+					Script: "#!/bin/sh\ncd /workspace && ls /cache\n",
+				}},
+			},
+		},
+	}
+
+	var got tknv1beta1.Step
+	le := &builds.LocalExecutor{
+		Workspace: tmpDir,
+		Steps: map[string]builds.LocalStep{
+			"build": func(ctx context.Context, step tknv1beta1.Step) error {
+				got = step
+				return nil
+			},
+		},
+	}
+	_, err = le.Execute(context.TODO(), "gcr.io/foo/bar:latest", tr)
+	require.NoError(t, err)
+
+	cacheDir := got.VolumeMounts[0].MountPath
+	assert.NotEqual(t, "/cache", cacheDir, "the mount path is rewritten")
+	assert.Equal(t, []string{
+		tmpDir,
+		tmpDir + "/Dockerfile",
+		"--dockerfile=" + tmpDir + "/Dockerfile",
+		"--context=dir://" + tmpDir + "/",
+		"--cache-repo=gcr.io/foo/cache",
+		"/cache2",
+		"/foo/workspace",
+		"/workspace.bak",
+	}, got.Args)
+	assert.Equal(t, tmpDir+"/svc", got.WorkingDir)
+	assert.Equal(t, []corev1.EnvVar{{
+		Name:  "GOCACHE",
+		Value: cacheDir + "/go",
+	}, {
+		Name:  "IMAGE",
+		Value: "gcr.io/foo/cache:latest",
+	}}, got.Env)
+	assert.Equal(t, "#!/bin/sh\ncd "+tmpDir+" && ls "+cacheDir+"\n", got.Script)
+}
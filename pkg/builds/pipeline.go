@@ -0,0 +1,268 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tektoncd/cli/pkg/cmd/pipelinerun"
+	"github.com/tektoncd/cli/pkg/options"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	tektonclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/apis"
+)
+
+const (
+	// SourceWorkspace is the name of the workspace into which the PipelineRuns
+	// produced by Pipeline fetch the source, and from which the builds read it.
+	SourceWorkspace = "source"
+
+	// FetchTaskName is the name of the pipeline task that fetches the source.
+	FetchTaskName = "fetch-source"
+
+	// sourceMountPath is where the source workspace is mounted, which stands in
+	// for /workspace within the steps.
+	sourceMountPath = WorkspaceDir + "/" + SourceWorkspace
+)
+
+// Pipeline combines the TaskRuns produced by our builders into a single PipelineRun,
+// which runs the source steps once to fetch the source into a shared workspace, and
+// then fans out to run the remaining steps of each TaskRun against that workspace.
+// The pipeline tasks and results are named after the keys of the TaskRuns, with each
+// result holding the IMAGE-DIGEST of the respective build.  Storage is the size of
+// the volume claimed for the source workspace.
+func Pipeline(sourceSteps []tknv1beta1.Step, trs map[string]*tknv1beta1.TaskRun, storage resource.Quantity) (*tknv1beta1.PipelineRun, error) {
+	if len(trs) == 0 {
+		return nil, fmt.Errorf("no TaskRuns to combine")
+	}
+	keys := make([]string, 0, len(trs))
+	for key := range trs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// The steps expect the source in /workspace, so point them at the workspace instead.
+	paths := newPathReplacer(map[string]string{WorkspaceDir: sourceMountPath})
+	workspaces := []tknv1beta1.WorkspaceDeclaration{{
+		Name:      SourceWorkspace,
+		MountPath: sourceMountPath,
+	}}
+	bindings := []tknv1beta1.WorkspacePipelineTaskBinding{{
+		Name:      SourceWorkspace,
+		Workspace: SourceWorkspace,
+	}}
+
+	skip := sets.NewString()
+	fetchSteps := make([]tknv1beta1.Step, 0, len(sourceSteps))
+	for _, step := range sourceSteps {
+		skip.Insert(step.Name)
+		fetchSteps = append(fetchSteps, localize(step, paths))
+	}
+
+	first := trs[keys[0]]
+	labels := make([]map[string]string, 0, len(keys))
+	annotations := make([]map[string]string, 0, len(keys))
+	for _, key := range keys {
+		labels = append(labels, trs[key].Labels)
+		annotations = append(annotations, trs[key].Annotations)
+	}
+	pr := &tknv1beta1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "mink-",
+			Namespace:    first.Namespace,
+			// The PipelineRun carries the metadata common to the builds.
+			Labels:      commonMetadata(labels...),
+			Annotations: commonMetadata(annotations...),
+		},
+		Spec: tknv1beta1.PipelineRunSpec{
+			ServiceAccountName: first.Spec.ServiceAccountName,
+			PodTemplate:        first.Spec.PodTemplate,
+			Workspaces: []tknv1beta1.WorkspaceBinding{{
+				Name: SourceWorkspace,
+				VolumeClaimTemplate: &corev1.PersistentVolumeClaim{
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceStorage: storage,
+							},
+						},
+					},
+				},
+			}},
+			PipelineSpec: &tknv1beta1.PipelineSpec{
+				Workspaces: []tknv1beta1.PipelineWorkspaceDeclaration{{
+					Name: SourceWorkspace,
+				}},
+				Tasks: []tknv1beta1.PipelineTask{{
+					Name: FetchTaskName,
+					TaskSpec: &tknv1beta1.EmbeddedTask{
+						TaskSpec: tknv1beta1.TaskSpec{
+							Workspaces: workspaces,
+							Steps:      fetchSteps,
+						},
+					},
+					Workspaces: bindings,
+				}},
+			},
+		},
+	}
+
+	for _, key := range keys {
+		tr := trs[key]
+		if tr.Spec.TaskSpec == nil {
+			return nil, fmt.Errorf("TaskRun %s has no inline TaskSpec", tr.GenerateName)
+		}
+		spec := tr.Spec.TaskSpec.DeepCopy()
+		steps := make([]tknv1beta1.Step, 0, len(spec.Steps))
+		for _, step := range spec.Steps {
+			if skip.Has(step.Name) {
+				continue
+			}
+			steps = append(steps, localize(step, paths))
+		}
+		spec.Steps = steps
+		spec.Workspaces = append(spec.Workspaces, workspaces...)
+
+		pr.Spec.PipelineSpec.Tasks = append(pr.Spec.PipelineSpec.Tasks, tknv1beta1.PipelineTask{
+			Name: key,
+			TaskSpec: &tknv1beta1.EmbeddedTask{
+				Metadata: tknv1beta1.PipelineTaskMetadata{
+					Labels:      tr.Labels,
+					Annotations: tr.Annotations,
+				},
+				TaskSpec: *spec,
+			},
+			RunAfter:   []string{FetchTaskName},
+			Workspaces: bindings,
+		})
+		pr.Spec.PipelineSpec.Results = append(pr.Spec.PipelineSpec.Results, tknv1beta1.PipelineResult{
+			Name:  key,
+			Value: fmt.Sprintf("$(tasks.%s.results.IMAGE-DIGEST)", key),
+		})
+		pr.Spec.TaskRunSpecs = append(pr.Spec.TaskRunSpecs, tknv1beta1.PipelineTaskRunSpec{
+			PipelineTaskName:       key,
+			TaskServiceAccountName: tr.Spec.ServiceAccountName,
+			TaskPodTemplate:        tr.Spec.PodTemplate,
+		})
+	}
+	return pr, nil
+}
+
+// ToPipeline turns the PipelineRun's embedded PipelineSpec into a standalone Pipeline.
+func ToPipeline(pr *tknv1beta1.PipelineRun) (*tknv1beta1.Pipeline, error) {
+	if pr.Spec.PipelineSpec == nil {
+		return nil, fmt.Errorf("PipelineRun %s has no inline PipelineSpec", pr.GenerateName)
+	}
+	return &tknv1beta1.Pipeline{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: tknv1beta1.SchemeGroupVersion.String(),
+			Kind:       "Pipeline",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      strings.TrimSuffix(pr.GenerateName, "-"),
+			Namespace: pr.Namespace,
+		},
+		Spec: *pr.Spec.PipelineSpec.DeepCopy(),
+	}, nil
+}
+
+// RunPipeline executes the provided PipelineRun, and returns its results (keyed by name)
+// upon completion.
+func RunPipeline(ctx context.Context, pr *tknv1beta1.PipelineRun, opt *options.LogOptions) (map[string]string, error) {
+	// TODO(mattmoor): expose masterURL and kubeconfig flags.
+	cfg, err := GetConfig("", "")
+	if err != nil {
+		return nil, err
+	}
+	client, err := tektonclientset.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	kubeclient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err = client.TektonV1beta1().PipelineRuns(pr.Namespace).Create(ctx, pr, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	owner := metav1.OwnerReference{
+		APIVersion: tknv1beta1.SchemeGroupVersion.String(),
+		Kind:       "PipelineRun",
+		Name:       pr.Name,
+		UID:        pr.UID,
+	}
+	for _, task := range pr.Spec.PipelineSpec.Tasks {
+		if task.TaskSpec != nil {
+			adoptCredentials(ctx, kubeclient, pr.Namespace, task.TaskSpec.Metadata.Annotations, owner)
+		}
+	}
+	defer client.TektonV1beta1().PipelineRuns(pr.Namespace).Delete(context.Background(), pr.Name, metav1.DeleteOptions{})
+
+	// When we are interrupted, cancel the pipeline before deleting it.
+	namespace, prName := pr.Namespace, pr.Name
+	defer func() {
+		if ctx.Err() != nil {
+			cancelPipelineRun(client, namespace, prName)
+		}
+	}()
+
+	opt.PipelineRunName = pr.Name
+	if err := streamLogs(ctx, func() error { return pipelinerun.Run(opt) }); err != nil {
+		return nil, err
+	}
+
+	// Spin waiting for the final status.
+	for {
+		// See if our context has been cancelled
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		// Fetch the final state of the pipeline.
+		pr, err = client.TektonV1beta1().PipelineRuns(pr.Namespace).Get(ctx, pr.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		// Return an error if the pipeline failed.
+		cond := pr.Status.GetCondition(apis.ConditionSucceeded)
+		if cond.IsFalse() {
+			return nil, fmt.Errorf("%s: %s", cond.Reason, cond.Message)
+		} else if !cond.IsTrue() {
+			continue
+		}
+
+		results := make(map[string]string, len(pr.Status.PipelineResults))
+		for _, result := range pr.Status.PipelineResults {
+			results[result.Name] = strings.TrimSpace(result.Value)
+		}
+		return results, nil
+	}
+}
@@ -0,0 +1,60 @@
+package builds_test
+
+import (
+	"testing"
+
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestPipeline(t *testing.T) {
+	sourceSteps := []tknv1beta1.Step{{
+		Container: corev1.Container{
+			Name:  "extract-bundle",
+			Image: "gcr.io/jenkins-x-labs-bdd/bundle",
+			Args:  []string{"--path", "/workspace"},
+		},
+	}}
+	newTaskRun := func(sa string) *tknv1beta1.TaskRun {
+		return &tknv1beta1.TaskRun{
+			Spec: tknv1beta1.TaskRunSpec{
+				ServiceAccountName: sa,
+				TaskSpec: &tknv1beta1.TaskSpec{
+					Steps: append(append([]tknv1beta1.Step{}, sourceSteps...), tknv1beta1.Step{
+						Container: corev1.Container{
+							Name:       "build-and-push",
+							Image:      "gcr.io/kaniko-project/executor:multi-arch",
+							WorkingDir: "/workspace/foo",
+						},
+					}),
+				},
+			},
+		}
+	}
+
+	pr, err := builds.Pipeline(sourceSteps, map[string]*tknv1beta1.TaskRun{
+		"build-1": newTaskRun("bar"),
+		"build-0": newTaskRun("foo"),
+	}, resource.MustParse("1Gi"))
+	require.NoError(t, err, "failed to create PipelineRun")
+
+	tasks := pr.Spec.PipelineSpec.Tasks
+	require.Len(t, tasks, 3)
+	assert.Equal(t, builds.FetchTaskName, tasks[0].Name)
+	assert.Equal(t, []string{"--path", "/workspace/source"}, tasks[0].TaskSpec.Steps[0].Args)
+
+	for i, name := range []string{"build-0", "build-1"} {
+		task := tasks[i+1]
+		assert.Equal(t, name, task.Name)
+		assert.Equal(t, []string{builds.FetchTaskName}, task.RunAfter)
+		require.Len(t, task.TaskSpec.Steps, 1, "the source steps should only run once")
+		assert.Equal(t, "/workspace/source/foo", task.TaskSpec.Steps[0].WorkingDir)
+		assert.Equal(t, "$(tasks."+name+".results.IMAGE-DIGEST)", pr.Spec.PipelineSpec.Results[i].Value)
+	}
+	assert.Equal(t, "foo", pr.Spec.ServiceAccountName)
+	assert.Equal(t, "bar", pr.Spec.TaskRunSpecs[1].TaskServiceAccountName)
+}
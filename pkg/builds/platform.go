@@ -0,0 +1,142 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/pool"
+)
+
+// ParsePlatform parses a platform in the form os/arch[/variant], e.g. linux/arm64/v8.
+func ParsePlatform(s string) (v1.Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return v1.Platform{}, fmt.Errorf("expected os/arch[/variant], got: %s", s)
+	}
+	p := v1.Platform{
+		OS:           parts[0],
+		Architecture: parts[1],
+	}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// PlatformString formats the platform as os/arch[/variant].
+func PlatformString(p v1.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// SelectPlatform schedules the TaskRun onto nodes of the provided platform, via the
+// well-known os and arch node labels (nodes are not labelled with the variant).
+func SelectPlatform(tr *tknv1beta1.TaskRun, p v1.Platform) {
+	if tr.Spec.PodTemplate == nil {
+		tr.Spec.PodTemplate = &tknv1beta1.PodTemplate{}
+	}
+	if tr.Spec.PodTemplate.NodeSelector == nil {
+		tr.Spec.PodTemplate.NodeSelector = make(map[string]string, 2)
+	}
+	tr.Spec.PodTemplate.NodeSelector[corev1.LabelOSStable] = p.OS
+	tr.Spec.PodTemplate.NodeSelector[corev1.LabelArchStable] = p.Architecture
+}
+
+// PlatformTag returns the tag to which the image for the provided platform is
+// published, before being assembled into the index published to the target.
+func PlatformTag(target name.Tag, p v1.Platform) (name.Tag, error) {
+	suffix := strings.ReplaceAll(PlatformString(p), "/", "-")
+	return name.NewTag(target.Context().String()+":"+target.TagStr()+"-"+suffix, name.WeakValidation)
+}
+
+// PlatformBuild builds the image for the platform, publishing it to the provided tag.
+type PlatformBuild func(ctx context.Context, p v1.Platform, tag name.Tag) (name.Digest, error)
+
+// BuildIndex runs a build for each of the platforms in parallel, and assembles the
+// resulting images into an OCI image index, which it publishes to the target,
+// returning the fully-qualified digest of the index.
+func BuildIndex(ctx context.Context, target name.Tag, platforms []v1.Platform, build PlatformBuild) (name.Digest, error) {
+	// The pool's context is cancelled once it has been waited upon.
+	errg, buildCtx := pool.NewWithContext(ctx, len(platforms), len(platforms))
+
+	var mu sync.Mutex
+	digests := make(map[int]name.Digest, len(platforms))
+	for i, p := range platforms {
+		i, p := i, p
+		errg.Go(func() error {
+			tag, err := PlatformTag(target, p)
+			if err != nil {
+				return err
+			}
+			digest, err := build(buildCtx, p, tag)
+			if err != nil {
+				return fmt.Errorf("failed to build %s for %s: %w", target, PlatformString(p), err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			digests[i] = digest
+			return nil
+		})
+	}
+	if err := errg.Wait(); err != nil {
+		return name.Digest{}, err
+	}
+	if IsDryRun(ctx) {
+		// Nothing was built, so return a placeholder digest.
+		return name.NewDigest(target.String() + "@sha256:" + strings.Repeat("0", 64))
+	}
+
+	auth := remote.WithAuthFromKeychain(authn.DefaultKeychain)
+	var idx v1.ImageIndex = mutate.IndexMediaType(empty.Index, types.OCIImageIndex)
+	for i := range platforms {
+		img, err := remote.Image(digests[i], auth, remote.WithContext(ctx))
+		if err != nil {
+			return name.Digest{}, fmt.Errorf("failed to fetch %s: %w", digests[i], err)
+		}
+		p := platforms[i]
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				Platform: &p,
+			},
+		})
+	}
+	if err := remote.WriteIndex(target, idx, auth, remote.WithContext(ctx)); err != nil {
+		return name.Digest{}, fmt.Errorf("failed to publish the image index to %s: %w", target, err)
+	}
+	h, err := idx.Digest()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	return name.NewDigest(target.String() + "@" + h.String())
+}
@@ -0,0 +1,63 @@
+package builds_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildIndex(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	target, err := name.NewTag(u.Host+"/mink/helloworld:latest", name.WeakValidation)
+	require.NoError(t, err)
+
+	var platforms []v1.Platform
+	for _, s := range []string{"linux/amd64", "linux/arm64/v8"} {
+		p, err := builds.ParsePlatform(s)
+		require.NoError(t, err)
+		platforms = append(platforms, p)
+	}
+
+	digest, err := builds.BuildIndex(context.TODO(), target, platforms, func(ctx context.Context, p v1.Platform, tag name.Tag) (name.Digest, error) {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			return name.Digest{}, err
+		}
+		if err := remote.Write(tag, img); err != nil {
+			return name.Digest{}, err
+		}
+		h, err := img.Digest()
+		if err != nil {
+			return name.Digest{}, err
+		}
+		return name.NewDigest(tag.String() + "@" + h.String())
+	})
+	require.NoError(t, err, "failed to build the index")
+
+	idx, err := remote.Index(digest)
+	require.NoError(t, err, "failed to fetch the index")
+	m, err := idx.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, m.Manifests, 2)
+	assert.Equal(t, "amd64", m.Manifests[0].Platform.Architecture)
+	assert.Equal(t, "v8", m.Manifests[1].Platform.Variant)
+
+	tag, err := name.NewTag(u.Host+"/mink/helloworld:latest-linux-arm64-v8", name.WeakValidation)
+	require.NoError(t, err)
+	_, err = remote.Head(tag)
+	assert.NoError(t, err, "the per-platform image should be published")
+}
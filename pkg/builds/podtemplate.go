@@ -0,0 +1,75 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// WithPodTemplate merges the scheduling controls of the provided pod template
+// (node selector, tolerations, affinity, priority and runtime class) into that
+// of the TaskRun, and applies the resource requirements to each of its steps.
+func WithPodTemplate(pt tknv1beta1.PodTemplate, resources corev1.ResourceRequirements) CancelableOption {
+	return func(ctx context.Context, tr *tknv1beta1.TaskRun) (context.CancelFunc, error) {
+		if tr.Spec.PodTemplate == nil {
+			tr.Spec.PodTemplate = &tknv1beta1.PodTemplate{}
+		}
+		merged := tr.Spec.PodTemplate
+
+		if len(pt.NodeSelector) > 0 && merged.NodeSelector == nil {
+			merged.NodeSelector = make(map[string]string, len(pt.NodeSelector))
+		}
+		for k, v := range pt.NodeSelector {
+			merged.NodeSelector[k] = v
+		}
+		merged.Tolerations = append(merged.Tolerations, pt.Tolerations...)
+		if pt.Affinity != nil {
+			merged.Affinity = pt.Affinity.DeepCopy()
+		}
+		if pt.PriorityClassName != nil {
+			merged.PriorityClassName = pt.PriorityClassName
+		}
+		if pt.RuntimeClassName != nil {
+			merged.RuntimeClassName = pt.RuntimeClassName
+		}
+
+		if tr.Spec.TaskSpec != nil {
+			for i := range tr.Spec.TaskSpec.Steps {
+				step := &tr.Spec.TaskSpec.Steps[i]
+				step.Resources.Requests = mergeResources(step.Resources.Requests, resources.Requests)
+				step.Resources.Limits = mergeResources(step.Resources.Limits, resources.Limits)
+			}
+		}
+		return func() {}, nil
+	}
+}
+
+func mergeResources(base, overrides corev1.ResourceList) corev1.ResourceList {
+	if len(overrides) == 0 {
+		return base
+	}
+	if base == nil {
+		base = make(corev1.ResourceList, len(overrides))
+	}
+	for k, v := range overrides {
+		base[k] = v.DeepCopy()
+	}
+	return base
+}
@@ -0,0 +1,65 @@
+package builds_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"knative.dev/pkg/ptr"
+)
+
+func TestWithPodTemplate(t *testing.T) {
+	tr := &tknv1beta1.TaskRun{
+		Spec: tknv1beta1.TaskRunSpec{
+			PodTemplate: &tknv1beta1.PodTemplate{
+				EnableServiceLinks: ptr.Bool(false),
+			},
+			TaskSpec: &tknv1beta1.TaskSpec{
+				Steps: []tknv1beta1.Step{{
+					Container: corev1.Container{Name: "extract-bundle"},
+				}, {
+					Container: corev1.Container{
+						Name: "build-and-push",
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceMemory: resource.MustParse("1Gi"),
+							},
+						},
+					},
+				}},
+			},
+		},
+	}
+
+	opt := builds.WithPodTemplate(tknv1beta1.PodTemplate{
+		NodeSelector: map[string]string{"pool": "builds"},
+		Tolerations: []corev1.Toleration{{
+			Key:      "dedicated",
+			Operator: corev1.TolerationOpExists,
+		}},
+		PriorityClassName: ptr.String("high"),
+	}, corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("1"),
+		},
+	})
+	cancel, err := opt(context.TODO(), tr)
+	require.NoError(t, err, "failed to apply the pod template")
+	defer cancel()
+
+	pt := tr.Spec.PodTemplate
+	assert.Equal(t, ptr.Bool(false), pt.EnableServiceLinks)
+	assert.Equal(t, map[string]string{"pool": "builds"}, pt.NodeSelector)
+	assert.Len(t, pt.Tolerations, 1)
+	assert.Equal(t, "high", *pt.PriorityClassName)
+	assert.Nil(t, pt.RuntimeClassName)
+
+	requests := tr.Spec.TaskSpec.Steps[1].Resources.Requests
+	assert.Equal(t, "1", requests.Cpu().String())
+	assert.Equal(t, "1Gi", requests.Memory().String())
+}
@@ -19,17 +19,19 @@ package command
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/mattmoor/mink/pkg/builds"
 	"github.com/mattmoor/mink/pkg/builds/dockerfile"
-	"github.com/mattmoor/mink/pkg/source"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"knative.dev/pkg/apis"
 	"knative.dev/pkg/signals"
-
-	"github.com/tektoncd/cli/pkg/cli"
-	"github.com/tektoncd/cli/pkg/options"
 )
 
 var dockerfileExample = fmt.Sprintf(`
@@ -73,18 +75,64 @@ type dockerfileOptions struct {
 	// Dockerfile is the relative path to the Dockerfile within the build context.
 	Dockerfile string
 
+	// Engine is the engine with which to build the Dockerfile.
+	Engine string
+
 	// KanikoImage the container image to use for kaniko
 	KanikoImage string
 
 	// The extra kaniko arguments for handling things like insecure registries
 	KanikoArgs []string
+
+	// KanikoBinary the kaniko binary to use if performing local builds
+	KanikoBinary string
+
+	// NoCache disables kaniko's caching.
+	NoCache bool
+
+	// CacheRepo is the repository in which kaniko caches layers.
+	CacheRepo string
+
+	// CacheTTL is how long cached layers remain valid.
+	CacheTTL time.Duration
+
+	// WarmImages are the base images with which to warm kaniko's cache.
+	WarmImages []string
+
+	// CachePVC is the PersistentVolumeClaim holding kaniko's cache of base images.
+	CachePVC string
+
+	// BuildArgs are the values of the Dockerfile's ARGs.
+	BuildArgs map[string]string
+
+	// Target is the stage of a multi-stage Dockerfile to build.
+	Target string
+
+	// ImageLabels are the labels to add to the image.
+	ImageLabels map[string]string
+
+	// Secrets are the names of the Secrets to make available to the build.
+	Secrets []string
 }
 
 // AddFlags implements Interface
 func (opts *dockerfileOptions) AddFlags(cmd *cobra.Command) {
 	cmd.Flags().String("dockerfile", "Dockerfile", "The path to the Dockerfile within the build context.")
+	cmd.Flags().String("engine", dockerfile.EngineKaniko,
+		"The engine with which to build Dockerfiles, one of: "+strings.Join(dockerfile.Engines, ", ")+".")
 	cmd.Flags().String("kaniko-image", dockerfile.KanikoImage, "The kaniko container image to use.")
 	cmd.Flags().StringSlice("kaniko-flag", nil, "Optional flag to pass to kaniko for dealing with insecure registries. For details see: https://github.com/GoogleContainerTools/kaniko/blob/master/README.md#additional-flags")
+	cmd.Flags().StringP("kaniko-binary", "", "/kaniko/executor", "The kaniko/executor binary location if using local builds.")
+	cmd.Flags().Bool("no-cache", false, "Disable the engine's caching of layers (and kaniko's caching of base images).")
+	cmd.Flags().String("cache-repo", "", "The repository in which the engine caches layers (defaults to the image's repository suffixed with /cache).")
+	cmd.Flags().Duration("cache-ttl", dockerfile.DefaultCacheTTL, "How long the layers kaniko caches remain valid.")
+	cmd.Flags().StringSlice("kaniko-warm-image", nil, "Base images with which to warm kaniko's cache of base images before the build.")
+	cmd.Flags().String("kaniko-cache-pvc", "", "The PersistentVolumeClaim holding kaniko's cache of base images, so it persists across builds.")
+	cmd.Flags().StringSlice("build-arg", nil, "KEY=VALUE setting an ARG of the Dockerfile.")
+	cmd.Flags().String("target", "", "The stage of a multi-stage Dockerfile to build.")
+	cmd.Flags().StringSlice("image-label", nil, "KEY=VALUE label to add to the image.")
+	cmd.Flags().StringSlice("build-secret", nil,
+		"The name of a Secret whose keys are made available to the build as files under "+dockerfile.SecretsDir+"/NAME/ (e.g. for npm tokens).")
 }
 
 // Validate implements Interface
@@ -100,11 +148,105 @@ func (opts *dockerfileOptions) Validate(cmd *cobra.Command, args []string) error
 		return apis.ErrMissingField("dockerfile")
 	}
 
+	opts.Engine = viper.GetString("engine")
+	if !sets.NewString(dockerfile.Engines...).Has(opts.Engine) {
+		return apis.ErrInvalidValue(opts.Engine, "engine")
+	}
+
 	opts.KanikoImage = viper.GetString("kaniko-image")
 	opts.KanikoArgs = viper.GetStringSlice("kaniko-flag")
+	opts.KanikoBinary = viper.GetString("kaniko-binary")
+
+	opts.NoCache = viper.GetBool("no-cache")
+	opts.CacheRepo = viper.GetString("cache-repo")
+	if opts.CacheRepo != "" {
+		if _, err := name.NewRepository(opts.CacheRepo, name.WeakValidation); err != nil {
+			return apis.ErrInvalidValue(err.Error(), "cache-repo")
+		}
+	}
+	opts.CacheTTL = viper.GetDuration("cache-ttl")
+	if opts.CacheTTL <= 0 {
+		return apis.ErrInvalidValue(opts.CacheTTL.String(), "cache-ttl")
+	}
+	opts.WarmImages = viper.GetStringSlice("kaniko-warm-image")
+	for _, img := range opts.WarmImages {
+		if _, err := name.ParseReference(img, name.WeakValidation); err != nil {
+			return apis.ErrInvalidValue(err.Error(), "kaniko-warm-image")
+		}
+	}
+	opts.CachePVC = viper.GetString("kaniko-cache-pvc")
+
+	var err error
+	if opts.BuildArgs, err = keyValues(viper.GetStringSlice("build-arg")); err != nil {
+		return apis.ErrInvalidValue(err.Error(), "build-arg")
+	}
+	opts.Target = viper.GetString("target")
+	if opts.ImageLabels, err = keyValues(viper.GetStringSlice("image-label")); err != nil {
+		return apis.ErrInvalidValue(err.Error(), "image-label")
+	}
+	opts.Secrets = viper.GetStringSlice("build-secret")
+	for _, secret := range opts.Secrets {
+		if errs := validation.IsDNS1123Subdomain(secret); len(errs) > 0 {
+			return apis.ErrInvalidValue(strings.Join(errs, "; "), "build-secret")
+		}
+	}
 	return nil
 }
 
+// options returns the options for a Dockerfile build of the Dockerfile at the
+// path within the build context.
+func (opts *dockerfileOptions) options(path string) dockerfile.Options {
+	return dockerfile.Options{
+		Dockerfile:  opts.Dockerfile,
+		Engine:      opts.Engine,
+		Path:        path,
+		KanikoImage: opts.KanikoImage,
+		KanikoArgs:  opts.KanikoArgs,
+		NoCache:     opts.NoCache,
+		CacheRepo:   opts.CacheRepo,
+		CacheTTL:    opts.CacheTTL,
+		WarmImages:  opts.WarmImages,
+		CachePVC:    opts.CachePVC,
+		BuildArgs:   opts.BuildArgs,
+		Target:      opts.Target,
+		Labels:      opts.ImageLabels,
+		Secrets:     opts.Secrets,
+	}
+}
+
+// keyValues parses a list of KEY=VALUE pairs.
+func keyValues(kvs []string) (map[string]string, error) {
+	if len(kvs) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("expected KEY=VALUE, got: %s", kv)
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m, nil
+}
+
+// tooling returns the local tooling with which to run the steps of a Dockerfile build
+// with the engine.
+func (opts *dockerfileOptions) tooling(engine string) builds.LocalExecutor {
+	le := builds.LocalExecutor{
+		// The warmer isn't available locally, where the base images are
+		// cached by whatever is running the build.
+		SkipSteps: sets.NewString(dockerfile.WarmStepName),
+	}
+	if engine == dockerfile.EngineKaniko {
+		// The other engines run their scripts, which invoke the tooling on the PATH.
+		le.Binaries = map[string]string{
+			dockerfile.StepName: opts.KanikoBinary,
+		}
+	}
+	return le
+}
+
 // BuildOptions implements Interface for the `kn im build` command.
 type BuildOptions struct {
 	// Inherit all of the base build options.
@@ -144,34 +286,29 @@ func (opts *BuildOptions) Execute(cmd *cobra.Command, args []string) error {
 	ctx := signals.NewContext()
 
 	// Bundle up the source context in an image or use git clone to get the source.
-	sourceSteps, nameRefs, err := source.CreateSourceSteps(ctx, opts.Directory, opts.BundleOptions.tag, opts.BundleOptions.GitLocation)
+	sourceSteps, nameRefs, err := opts.sourceSteps(ctx)
 	if err != nil {
 		return err
 	}
 
 	// Create a Build definition for turning the source into an image by Dockerfile build.
-	tr := dockerfile.Build(ctx, sourceSteps, opts.tag, dockerfile.Options{
-		Dockerfile:  opts.Dockerfile,
-		KanikoImage: opts.KanikoImage,
-		KanikoArgs:  opts.KanikoArgs,
-	})
-	tr.Namespace = Namespace()
-
-	// Run the produced Build definition to completion, streaming logs to stdout, and
-	// returning the digest of the produced image.
-	digest, err := builds.Run(ctx, opts.ImageName, tr, &options.LogOptions{
-		Params: &cli.TektonParams{},
-		Stream: &cli.Stream{
-			// Send Out to stderr so we can capture the digest for composition.
-			Out: cmd.OutOrStderr(),
-			Err: cmd.OutOrStderr(),
-		},
-		Follow: true,
-	}, builds.WithServiceAccount(opts.ServiceAccount, nameRefs...))
+	build := func(tag name.Tag) (*tknv1beta1.TaskRun, error) {
+		tr := dockerfile.Build(ctx, sourceSteps, tag, opts.dockerfileOptions.options(""))
+		tr.Namespace = Namespace()
+		return tr, nil
+	}
+
+	// Run the produced Build definition to completion (for each platform), streaming
+	// logs to stderr (so we can capture the digest for composition), and returning
+	// the digest of the produced image.
+	executor := opts.executor("dockerfile", opts.Local, sourceSteps, nameRefs, cmd.OutOrStderr(), opts.tooling(opts.Engine))
+	digest, err := opts.executePlatforms(ctx, executor, opts.ImageName, opts.tag, build)
 	if err != nil {
 		return err
 	}
 
-	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", digest.String())
+	if !opts.DryRun {
+		fmt.Fprintf(cmd.OutOrStdout(), "%s\n", digest.String())
+	}
 	return nil
 }
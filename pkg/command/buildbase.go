@@ -17,9 +17,26 @@ limitations under the License.
 package command
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/user"
+	"strings"
+
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/source"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/tektoncd/cli/pkg/cli"
+	"github.com/tektoncd/cli/pkg/options"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"knative.dev/pkg/apis"
 )
 
@@ -28,6 +45,9 @@ type BaseBuildOptions struct {
 	// Inherit all of the bundle options.
 	BundleOptions
 
+	// Inherit the controls over the build pods.
+	podTemplateOptions
+
 	// ImageName is the string name of the bundle image to which we should publish things.
 	ImageName string
 
@@ -37,6 +57,27 @@ type BaseBuildOptions struct {
 	// ServiceAccount is the name of the service account *as* which to run the build.
 	ServiceAccount string
 
+	// Local runs the build steps locally rather than in a TaskRun.
+	Local bool
+
+	// DryRun prints the TaskRuns rather than running them.
+	DryRun bool
+
+	// DryRunOutput is the format in which DryRun prints things.
+	DryRunOutput string
+
+	// EmitTask prints reusable Tasks in place of the TaskRuns when DryRun is set.
+	EmitTask bool
+
+	// dryRunOut is where DryRun prints things.
+	dryRunOut io.Writer
+
+	// Platforms are the platforms for which to build the image, if any.
+	Platforms []v1.Platform
+
+	// Labels are the user-defined labels to add to everything we create for the build.
+	Labels map[string]string
+
 	// NoImageValidate disables image validation if using this command from a composite opertation
 	// which may resolve the image string using expressions
 	NoImageValidate bool
@@ -49,11 +90,26 @@ var _ Interface = (*BaseBuildOptions)(nil)
 func (opts *BaseBuildOptions) AddFlags(cmd *cobra.Command) {
 	// Add the bundle flags to our surface.
 	opts.BundleOptions.AddFlags(cmd)
+	opts.podTemplateOptions.AddFlags(cmd)
 
 	cmd.Flags().String("image", "", "Where to publish the final image.")
 	cmd.Flags().String("as", "default",
 		"The name of the ServiceAccount as which to run the build, pass --as=me to "+
 			"temporarily create a new ServiceAccount to push with your local credentials.")
+	cmd.Flags().Bool("local", false,
+		"Runs the build steps locally instead of creating a TaskRun. Typically used inside a Pipeline whose image contains the build tooling.")
+	cmd.Flags().Bool("dry-run", false,
+		"Print the TaskRuns that would be run instead of running them, without publishing the source bundle. "+
+			"Builders (e.g. buildpack builder images) are still fetched from their registries to plan the builds.")
+	cmd.Flags().StringP("dry-run-output", "o", "yaml", "The format in which --dry-run prints TaskRuns: yaml or json.")
+	cmd.Flags().StringSlice("platform", nil,
+		"The os/arch[/variant] platforms for which to build the image, e.g. linux/amd64,linux/arm64. "+
+			"With several platforms we run a build for each (on nodes of that platform), and publish an image index.")
+	cmd.Flags().StringSlice("label", nil,
+		"A KEY=VALUE label to add to the TaskRuns (and everything created for them), may be repeated. "+
+			"The app.kubernetes.io/managed-by and mink.dev/ labels are reserved.")
+	cmd.Flags().Bool("emit-task", false,
+		"With --dry-run, print reusable Tekton Tasks (taking the image as the IMAGE parameter, and its repository as IMAGE-REPO) instead of TaskRuns.")
 }
 
 // Validate implements Interface
@@ -62,6 +118,9 @@ func (opts *BaseBuildOptions) Validate(cmd *cobra.Command, args []string) error
 	if err := opts.BundleOptions.Validate(cmd, args); err != nil {
 		return err
 	}
+	if err := opts.podTemplateOptions.Validate(cmd, args); err != nil {
+		return err
+	}
 
 	opts.ImageName = viper.GetString("image")
 	if opts.ImageName == "" {
@@ -78,5 +137,191 @@ func (opts *BaseBuildOptions) Validate(cmd *cobra.Command, args []string) error
 		return apis.ErrMissingField("as")
 	}
 
+	opts.Local = viper.GetBool("local")
+
+	opts.DryRun = viper.GetBool("dry-run")
+	opts.DryRunOutput = viper.GetString("dry-run-output")
+	switch opts.DryRunOutput {
+	case "yaml", "json":
+	default:
+		return apis.ErrInvalidValue(opts.DryRunOutput, "dry-run-output")
+	}
+	opts.EmitTask = viper.GetBool("emit-task")
+
+	opts.Platforms = nil
+	for _, s := range viper.GetStringSlice("platform") {
+		p, err := builds.ParsePlatform(s)
+		if err != nil {
+			return apis.ErrInvalidValue(err.Error(), "platform")
+		}
+		opts.Platforms = append(opts.Platforms, p)
+	}
+
+	opts.Labels = nil
+	for _, kv := range viper.GetStringSlice("label") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return apis.ErrInvalidValue(kv, "label")
+		}
+		if errs := validation.IsQualifiedName(parts[0]); len(errs) > 0 {
+			return apis.ErrInvalidValue(strings.Join(errs, "; "), "label")
+		}
+		if errs := validation.IsValidLabelValue(parts[1]); len(errs) > 0 {
+			return apis.ErrInvalidValue(strings.Join(errs, "; "), "label")
+		}
+		// Our own labels are how `mink gc` (and users) find what we created.
+		if parts[0] == builds.ManagedByLabel || strings.HasPrefix(parts[0], "mink.dev/") {
+			return apis.ErrInvalidValue(fmt.Sprintf("%s is reserved", parts[0]), "label")
+		}
+		if opts.Labels == nil {
+			opts.Labels = make(map[string]string, 1)
+		}
+		opts.Labels[parts[0]] = parts[1]
+	}
+	opts.dryRunOut = cmd.OutOrStdout()
+
 	return nil
 }
+
+// executor returns the builds.Executor with which to run a build over the provided
+// source steps, either locally (with the step binaries and in-process steps of the
+// provided tooling) or as a TaskRun, unless this is a dry-run.
+func (opts *BaseBuildOptions) executor(builder string, local bool, sourceSteps []tknv1beta1.Step, nameRefs []name.Reference, out io.Writer, tooling builds.LocalExecutor) builds.Executor {
+	if opts.DryRun {
+		return &builds.DryRunExecutor{
+			Out:      opts.dryRunOut,
+			Format:   opts.DryRunOutput,
+			EmitTask: opts.EmitTask,
+			Options:  opts.buildOptions(builder, nameRefs),
+		}
+	}
+	if local {
+		skip := sets.NewString(tooling.SkipSteps.List()...)
+		for _, step := range sourceSteps {
+			skip.Insert(step.Name)
+		}
+		le := tooling
+		le.Workspace = opts.Directory
+		le.SkipSteps = skip
+		le.Stdout = out
+		le.Stderr = out
+		return &le
+	}
+	return &builds.TektonExecutor{
+		LogOptions: &options.LogOptions{
+			Params: &cli.TektonParams{},
+			Stream: &cli.Stream{
+				Out: out,
+				Err: out,
+			},
+			Follow: true,
+		},
+		Options: opts.buildOptions(builder, nameRefs),
+	}
+}
+
+// executePlatforms executes the TaskRun produced by build to publish the image to
+// target, once for each of the platforms when there are several (on nodes of that
+// platform), assembling the resulting images into an image index published to target.
+func (opts *BaseBuildOptions) executePlatforms(ctx context.Context, executor builds.Executor, image string, target name.Tag, build func(name.Tag) (*tknv1beta1.TaskRun, error)) (name.Digest, error) {
+	if len(opts.Platforms) <= 1 {
+		tr, err := build(target)
+		if err != nil {
+			return name.Digest{}, err
+		}
+		if len(opts.Platforms) == 1 {
+			builds.SelectPlatform(tr, opts.Platforms[0])
+		}
+		return executor.Execute(ctx, image, tr)
+	}
+
+	if _, ok := executor.(*builds.LocalExecutor); ok {
+		return name.Digest{}, errors.New("builds for multiple platforms cannot be run locally")
+	}
+	if opts.DryRun {
+		ctx = builds.WithDryRun(ctx)
+	}
+	return builds.BuildIndex(ctx, target, opts.Platforms, func(ctx context.Context, p v1.Platform, tag name.Tag) (name.Digest, error) {
+		tr, err := build(tag)
+		if err != nil {
+			return name.Digest{}, err
+		}
+		builds.SelectPlatform(tr, p)
+		return executor.Execute(ctx, tag.String(), tr)
+	})
+}
+
+// sourceSteps returns the steps that fetch the source into the build, along with the
+// references to which the build needs access.  Unless this is a dry-run, the source
+// is bundled up and published to --bundle (when not cloned from git).
+func (opts *BaseBuildOptions) sourceSteps(ctx context.Context) ([]tknv1beta1.Step, []name.Reference, error) {
+	if opts.DryRun {
+		steps, refs := source.PlanSourceSteps(opts.BundleOptions.tag, opts.BundleOptions.GitLocation)
+		return steps, refs, nil
+	}
+	return source.CreateSourceSteps(ctx, opts.Directory, opts.BundleOptions.tag, opts.BundleOptions.GitLocation)
+}
+
+// platformStrings returns the platforms as os/arch[/variant] strings.
+func (opts *BaseBuildOptions) platformStrings() []string {
+	if len(opts.Platforms) == 0 {
+		return nil
+	}
+	ss := make([]string, 0, len(opts.Platforms))
+	for _, p := range opts.Platforms {
+		ss = append(ss, builds.PlatformString(p))
+	}
+	return ss
+}
+
+// buildOptions returns the options to apply to the TaskRuns of the named builder
+// that we run in-cluster.
+func (opts *BaseBuildOptions) buildOptions(builder string, nameRefs []name.Reference) []builds.CancelableOption {
+	labels, annotations := opts.metadata()
+	return []builds.CancelableOption{
+		// This comes first, so that the credentials carry the metadata too.
+		builds.WithMetadata(labels, annotations),
+		builds.WithServiceAccount(opts.ServiceAccount, nameRefs...),
+		opts.podTemplateOptions.option(builder),
+	}
+}
+
+// metadata returns the labels and annotations for everything we create for the build,
+// noting who built what from where, along with the user-defined labels.
+func (opts *BaseBuildOptions) metadata() (map[string]string, map[string]string) {
+	// The user-defined labels come first, so they cannot clobber ours.
+	labels := make(map[string]string, len(opts.Labels)+5)
+	for k, v := range opts.Labels {
+		labels[k] = v
+	}
+	labels[builds.ManagedByLabel] = builds.ManagedBy
+	annotations := map[string]string{}
+
+	username := os.Getenv("USER")
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		username = u.Username
+	}
+	if username != "" {
+		labels[builds.UserLabel] = builds.LabelValue(username)
+		annotations[builds.UserAnnotation] = username
+	}
+
+	if gl := opts.GitLocation; gl != nil {
+		if gl.URL != "" {
+			repo, annotation := gl.URL, gl.URL
+			if u, err := url.Parse(gl.URL); err == nil && u.Host != "" {
+				repo = u.Host + u.Path
+				// Don't leak any credentials embedded in the URL.
+				u.User = nil
+				annotation = u.String()
+			}
+			labels[builds.GitRepositoryLabel] = builds.LabelValue(strings.TrimSuffix(repo, ".git"))
+			annotations[builds.GitURLAnnotation] = annotation
+		}
+		if gl.Revision != "" {
+			labels[builds.GitRevisionLabel] = builds.LabelValue(gl.Revision)
+			annotations[builds.GitRevisionAnnotation] = gl.Revision
+		}
+	}
+	return labels, annotations
+}
@@ -19,14 +19,14 @@ package command
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
 
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/mattmoor/mink/pkg/builds"
 	"github.com/mattmoor/mink/pkg/builds/buildpacks"
-	"github.com/mattmoor/mink/pkg/source"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/tektoncd/cli/pkg/cli"
-	"github.com/tektoncd/cli/pkg/options"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	"knative.dev/pkg/apis"
 	"knative.dev/pkg/signals"
 )
@@ -45,6 +45,10 @@ var buildpacksExample = fmt.Sprintf(`
   # As the first, but uses a different builder image.
   %[1]s buildpack --builder=cloudfoundry/cnb:bionic --image docker.io/mattmoor/bundle:latest
 
+  # As the first, but only prints which buildpacks would participate in the
+  # build (and their build plan), without building or publishing an image.
+  %[1]s buildpack --detect-only --image docker.io/mattmoor/bundle:latest
+
   # As the first, but executes the build as a temporary ServiceAccount
   # that is configured with the user's local credentials.
   # WARNING: This temporarily places your registry credentials in a Secret
@@ -74,6 +78,23 @@ type buildpackOptions struct {
 
 	// OverrideFile holds the name of the file that overrides project.toml settings.
 	OverrideFile string
+
+	// Env holds the environment variables to pass to the buildpacks, which take
+	// precedence over those in project.toml and the overrides file.
+	Env map[string]string
+
+	// RunImage overrides the stack's run image.
+	RunImage string
+
+	// TrustBuilder runs the lifecycle of the builder in a single container,
+	// which has the registry credentials throughout.
+	TrustBuilder bool
+
+	// CacheImage is the image in which to cache layers across builds.
+	CacheImage string
+
+	// CachePVC is the PersistentVolumeClaim in which to cache layers across builds.
+	CachePVC string
 }
 
 // AddFlags implements Interface
@@ -83,6 +104,19 @@ func (opts *buildpackOptions) AddFlags(cmd *cobra.Command) {
 
 	cmd.Flags().String("overrides", "overrides.toml",
 		"The name of the file to read project.toml overrides from.")
+
+	cmd.Flags().StringSlice("env", nil,
+		"KEY=VALUE environment variable (e.g. BP_JVM_VERSION=11) to pass to the buildpacks, overriding project.toml.")
+
+	cmd.Flags().String("run-image", "",
+		"The run image on which to base the image, overriding the stack's run image.")
+	cmd.Flags().Bool("trust-builder", false,
+		"Run the builder's whole lifecycle via its creator in a single container, which has the registry credentials throughout. Only use this with builders you trust.")
+	cmd.Flags().String("cache-image", "",
+		"The image in which to cache layers across builds. Without a value, the image's tag in its repository's /cache sub-repository.")
+	cmd.Flags().Lookup("cache-image").NoOptDefVal = buildpacks.DefaultCacheImage
+	cmd.Flags().String("cache-pvc", "",
+		"The PersistentVolumeClaim in which to cache layers across builds.")
 }
 
 // Validate implements Interface
@@ -96,15 +130,79 @@ func (opts *buildpackOptions) Validate(cmd *cobra.Command, args []string) error
 	if opts.OverrideFile == "" {
 		return apis.ErrMissingField("overrides")
 	}
+
+	var err error
+	if opts.Env, err = keyValues(viper.GetStringSlice("env")); err != nil {
+		return apis.ErrInvalidValue(err.Error(), "env")
+	}
+
+	opts.RunImage = viper.GetString("run-image")
+	if opts.RunImage != "" {
+		if _, err := name.ParseReference(opts.RunImage, name.WeakValidation); err != nil {
+			return apis.ErrInvalidValue(err.Error(), "run-image")
+		}
+	}
+
+	opts.TrustBuilder = viper.GetBool("trust-builder")
+
+	opts.CacheImage = viper.GetString("cache-image")
+	if opts.CacheImage != "" && opts.CacheImage != buildpacks.DefaultCacheImage {
+		if _, err := name.NewTag(opts.CacheImage, name.WeakValidation); err != nil {
+			return apis.ErrInvalidValue(err.Error(), "cache-image")
+		}
+	}
+	opts.CachePVC = viper.GetString("cache-pvc")
+	if opts.CacheImage != "" && opts.CachePVC != "" {
+		return apis.ErrMultipleOneOf("cache-image", "cache-pvc")
+	}
 	return nil
 }
 
+// options returns the options for a buildpack build of the source at the path
+// within the build context, whose local copy is in dir.  The build environment
+// is that configured by the project.toml and overrides file there, overridden
+// by our --env flags, which in turn are overridden by env.
+func (opts *buildpackOptions) options(dir, path string, env map[string]string) (buildpacks.Options, error) {
+	merged, err := buildpacks.ProjectEnv(filepath.Join(dir, path), opts.OverrideFile)
+	if err != nil {
+		return buildpacks.Options{}, err
+	}
+	for k, v := range opts.Env {
+		merged[k] = v
+	}
+	for k, v := range env {
+		merged[k] = v
+	}
+	return buildpacks.Options{
+		Builder:      opts.Builder,
+		OverrideFile: opts.OverrideFile,
+		Path:         path,
+		Env:          buildpacks.EnvVars(merged),
+		RunImage:     opts.RunImage,
+		TrustBuilder: opts.TrustBuilder,
+		CacheImage:   opts.CacheImage,
+		CachePVC:     opts.CachePVC,
+	}, nil
+}
+
+// tooling returns the local tooling with which to run the steps of a buildpack build
+// from within the builder image.
+func (opts *buildpackOptions) tooling() builds.LocalExecutor {
+	return builds.LocalExecutor{
+		Steps: buildpacks.LocalSteps(),
+	}
+}
+
 // BuildpackOptions implements Interface for the `kn im build` command.
 type BuildpackOptions struct {
 	// Inherit all of the base build options.
 	BaseBuildOptions
 
 	buildpackOptions
+
+	// DetectOnly stops after detection, printing the group of buildpacks that
+	// would participate in the build and their build plan.
+	DetectOnly bool
 }
 
 // BuildpackOptions implements Interface
@@ -116,6 +214,9 @@ func (opts *BuildpackOptions) AddFlags(cmd *cobra.Command) {
 	opts.BaseBuildOptions.AddFlags(cmd)
 
 	opts.buildpackOptions.AddFlags(cmd)
+
+	cmd.Flags().Bool("detect-only", false,
+		"Only run detection, printing the resolved group.toml and plan.toml instead of building and publishing the image.")
 }
 
 // Validate implements Interface
@@ -125,7 +226,15 @@ func (opts *BuildpackOptions) Validate(cmd *cobra.Command, args []string) error
 		return err
 	}
 
-	return opts.buildpackOptions.Validate(cmd, args)
+	if err := opts.buildpackOptions.Validate(cmd, args); err != nil {
+		return err
+	}
+
+	opts.DetectOnly = viper.GetBool("detect-only")
+	if opts.DetectOnly && len(opts.Platforms) > 1 {
+		return apis.ErrMultipleOneOf("detect-only", "platform")
+	}
+	return nil
 }
 
 // Execute implements Interface
@@ -138,33 +247,45 @@ func (opts *BuildpackOptions) Execute(cmd *cobra.Command, args []string) error {
 	ctx := signals.NewContext()
 
 	// Bundle up the source context in an image or use git clone to get the source.
-	sourceSteps, nameRefs, err := source.CreateSourceSteps(ctx, opts.Directory, opts.BundleOptions.tag, opts.BundleOptions.GitLocation)
+	sourceSteps, nameRefs, err := opts.sourceSteps(ctx)
 	if err != nil {
 		return err
 	}
 
 	// Create a Build definition for turning the source into an image via CNCF Buildpacks.
-	tr := buildpacks.Build(ctx, sourceSteps, opts.tag, buildpacks.Options{
-		Builder:      opts.Builder,
-		OverrideFile: opts.OverrideFile,
-	})
-	tr.Namespace = Namespace()
-
-	// Run the produced Build definition to completion, streaming logs to stdout, and
-	// returning the digest of the produced image.
-	digest, err := builds.Run(ctx, opts.ImageName, tr, &options.LogOptions{
-		Params: &cli.TektonParams{},
-		Stream: &cli.Stream{
-			// Send Out to stderr so we can capture the digest for composition.
-			Out: cmd.OutOrStderr(),
-			Err: cmd.OutOrStderr(),
-		},
-		Follow: true,
-	}, builds.WithServiceAccount(opts.ServiceAccount, nameRefs...))
+	bpOpts, err := opts.buildpackOptions.options(opts.Directory, "", nil)
+	if err != nil {
+		return err
+	}
+	if opts.Local {
+		// The source is already in our working directory.
+		bpOpts.AppDir = builds.WorkspaceDir
+	}
+	bpOpts.DetectOnly = opts.DetectOnly
+	build := func(tag name.Tag) (*tknv1beta1.TaskRun, error) {
+		tr, err := buildpacks.Build(ctx, sourceSteps, tag, bpOpts)
+		if err != nil {
+			return nil, err
+		}
+		tr.Namespace = Namespace()
+		return tr, nil
+	}
+
+	// Run the produced Build definition to completion (for each platform), streaming
+	// logs to stderr (so we can capture the digest for composition), and returning
+	// the digest of the produced image.  When only detecting, the logs are the output.
+	out := cmd.OutOrStderr()
+	if opts.DetectOnly {
+		out = cmd.OutOrStdout()
+	}
+	executor := opts.executor("buildpack", opts.Local, sourceSteps, nameRefs, out, opts.tooling())
+	digest, err := opts.executePlatforms(ctx, executor, opts.ImageName, opts.tag, build)
 	if err != nil {
 		return err
 	}
 
-	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", digest.String())
+	if !opts.DryRun && !opts.DetectOnly {
+		fmt.Fprintf(cmd.OutOrStdout(), "%s\n", digest.String())
+	}
 	return nil
 }
@@ -0,0 +1,75 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildpackOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		wantErr  string
+	}{{
+		name: "defaults",
+	}, {
+		name:     "cache image",
+		settings: map[string]interface{}{"cache-image": "gcr.io/foo/cache:latest"},
+	}, {
+		name:     "cache pvc",
+		settings: map[string]interface{}{"cache-pvc": "buildpacks-cache"},
+	}, {
+		name: "both caches",
+		settings: map[string]interface{}{
+			"cache-image": "gcr.io/foo/cache:latest",
+			"cache-pvc":   "buildpacks-cache",
+		},
+		wantErr: "expected exactly one, got both: cache-image, cache-pvc",
+	}, {
+		name:     "invalid cache image",
+		settings: map[string]interface{}{"cache-image": "Not An Image"},
+		wantErr:  "cache-image",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("builder", "paketobuildpacks/builder:base")
+			viper.Set("overrides", "overrides.toml")
+			for k, v := range test.settings {
+				viper.Set(k, v)
+			}
+			t.Cleanup(func() {
+				for _, k := range []string{"builder", "overrides", "cache-image", "cache-pvc"} {
+					viper.Set(k, nil)
+				}
+			})
+
+			err := (&buildpackOptions{}).Validate(nil, nil)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
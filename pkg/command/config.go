@@ -0,0 +1,84 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigFiles returns the .mink.yaml files from which our configuration is read, in the
+// order in which they are merged: the nearest to the working directory, and then the one
+// in the home directory.
+func ConfigFiles() ([]string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, 2)
+	for ; wd != filepath.Dir(wd); wd = filepath.Dir(wd) {
+		p := filepath.Join(wd, minkFileName)
+		if _, err := os.Stat(p); err == nil {
+			files = append(files, p)
+			break
+		}
+	}
+	p := filepath.Join(home, minkFileName)
+	if _, err := os.Stat(p); err == nil && (len(files) == 0 || files[0] != p) {
+		files = append(files, p)
+	}
+	return files, nil
+}
+
+// builderConfigDir returns the directory of the .mink.yaml that configures the Task
+// file of the builder of the scheme, which is the last to do so, as it is merged over
+// the others.
+func builderConfigDir(scheme string) (string, bool) {
+	files, err := ConfigFiles()
+	if err != nil {
+		return "", false
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		data, err := ioutil.ReadFile(files[i])
+		if err != nil {
+			continue
+		}
+		var config struct {
+			Builders map[string]customBuilder `json:"builders"`
+		}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			continue
+		}
+		for s, cb := range config.Builders {
+			// viper lowercases the schemes.
+			if strings.EqualFold(s, scheme) && cb.Task != "" {
+				return filepath.Dir(files[i]), true
+			}
+		}
+	}
+	return "", false
+}
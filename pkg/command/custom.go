@@ -0,0 +1,196 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds/custom"
+	"github.com/spf13/viper"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"knative.dev/pkg/apis"
+)
+
+// customBuilder configures a scheme of references that are built by a Tekton Task,
+// which may only be configured in .mink.yaml, e.g.
+//
+//	builders:
+//	  jib:
+//	    task: hack/jib.yaml
+//	  bazel:
+//	    bundle: gcr.io/foo/builders:v1
+//	    name: bazel
+//	    params:
+//	      TARGET: //cmd/app:image
+type customBuilder struct {
+	// Task is the path of a YAML file holding the Task, relative to the
+	// .mink.yaml that configures it.
+	Task string `json:"task,omitempty"`
+
+	// Bundle is a Tekton bundle holding the Task, which is named Name (or
+	// else after the scheme).
+	Bundle string `json:"bundle,omitempty"`
+	Name   string `json:"name,omitempty"`
+
+	// Params holds the values of parameters of the Task for all references,
+	// which their queries override.
+	Params map[string]string `json:"params,omitempty"`
+}
+
+var schemeRegexp = regexp.MustCompile(`^[a-z][a-z0-9+.-]*$`)
+
+// customBuilders returns the builders of the schemes configured under 'builders'
+// in .mink.yaml.
+func (opts *ResolveOptions) customBuilders() (map[string]builder, error) {
+	if !viper.IsSet("builders") {
+		return nil, nil
+	}
+	var cbs map[string]customBuilder
+	if err := decodeConfig(viper.Get("builders"), &cbs); err != nil {
+		return nil, apis.ErrInvalidValue(err.Error(), "builders")
+	}
+
+	builders := make(map[string]builder, len(cbs))
+	for scheme, cb := range cbs {
+		field := "builders." + scheme
+		if _, ok := opts.builders[scheme]; ok || !schemeRegexp.MatchString(scheme) {
+			return nil, apis.ErrInvalidKeyName(scheme, "builders")
+		}
+		switch {
+		case cb.Task == "" && cb.Bundle == "":
+			return nil, apis.ErrMissingOneOf(field+".task", field+".bundle")
+		case cb.Task != "" && cb.Bundle != "":
+			return nil, apis.ErrMultipleOneOf(field+".task", field+".bundle")
+		case cb.Bundle != "":
+			if _, err := name.ParseReference(cb.Bundle, name.WeakValidation); err != nil {
+				return nil, apis.ErrInvalidValue(err.Error(), field+".bundle")
+			}
+		}
+		if cb.Task != "" && !filepath.IsAbs(cb.Task) {
+			// Task files are relative to the .mink.yaml that configures them, which
+			// is usually the one in --directory.
+			dir, ok := builderConfigDir(scheme)
+			if !ok {
+				dir = opts.Directory
+			}
+			cb.Task = filepath.Join(dir, cb.Task)
+		}
+		builders[scheme] = opts.custom(scheme, cb)
+	}
+	return builders, nil
+}
+
+// custom returns the builder of references of the scheme, which loads its Task
+// when first used.
+func (opts *ResolveOptions) custom(scheme string, cb customBuilder) builder {
+	var (
+		once    sync.Once
+		task    *tknv1beta1.TaskSpec
+		loadErr error
+	)
+	load := func(ctx context.Context) (*tknv1beta1.TaskSpec, error) {
+		once.Do(func() {
+			if cb.Bundle == "" {
+				task, loadErr = custom.LoadFile(cb.Task)
+				return
+			}
+			taskName := cb.Name
+			if taskName == "" {
+				taskName = scheme
+			}
+			task, loadErr = custom.LoadBundle(ctx, cb.Bundle, taskName)
+		})
+		return task, loadErr
+	}
+
+	return func(ctx context.Context, sourceSteps []tknv1beta1.Step, u *url.URL) (*buildPlan, error) {
+		if u.Host != "" {
+			return nil, fmt.Errorf(
+				"unexpected host in %q reference, got: %s (did you mean %s:/// instead of %s://?)",
+				u.Scheme, u.Host, u.Scheme, u.Scheme)
+		}
+		task, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		q, image, err := opts.dirConfig(u)
+		if err != nil {
+			return nil, err
+		}
+		imageName, tag, err := opts.resolveImageName(image, u.Path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(opts.cmd.OutOrStderr(), "building image %s\n", imageName)
+
+		// The query of the reference holds values of the Task's parameters, e.g.
+		// jib:///path?MAVEN_IMAGE=maven:3-openjdk-17
+		params := make(map[string]string, len(cb.Params)+len(q))
+		for k, v := range cb.Params {
+			params[paramName(task, k)] = v
+		}
+		for k, values := range q {
+			params[k] = values[len(values)-1]
+		}
+		cOpts := custom.Options{
+			Builder: scheme,
+			Task:    task,
+			Path:    u.Path,
+			Params:  params,
+		}
+
+		build := func(tag name.Tag) (*tknv1beta1.TaskRun, error) {
+			tr, err := custom.Build(ctx, sourceSteps, tag, cOpts)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %q reference: %w", u, err)
+			}
+			tr.Namespace = Namespace()
+			return tr, nil
+		}
+		tr, err := build(tag)
+		if err != nil {
+			return nil, err
+		}
+
+		return &buildPlan{
+			imageName: imageName,
+			tag:       tag,
+			tr:        tr,
+			build:     build,
+			local:     opts.Local,
+		}, nil
+	}
+}
+
+// paramName returns the name of the Task's parameter matching the key of a param
+// in .mink.yaml, whose keys viper lowercases.
+func paramName(task *tknv1beta1.TaskSpec, key string) string {
+	for _, p := range task.Params {
+		if strings.EqualFold(p.Name, key) {
+			return p.Name
+		}
+	}
+	return key
+}
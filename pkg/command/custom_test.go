@@ -0,0 +1,222 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const echoTask = `apiVersion: tekton.dev/v1beta1
+kind: Task
+metadata:
+  name: echo
+spec:
+  params:
+  - name: IMAGE
+  - name: CONTEXT
+  - name: MESSAGE
+  results:
+  - name: IMAGE-DIGEST
+  steps:
+  - name: build
+    image: busybox
+    script: echo "$(params.MESSAGE)" > $(results.IMAGE-DIGEST.path)
+`
+
+// withBuilders configures the builders of .mink.yaml until the test ends.
+func withBuilders(t *testing.T, builders interface{}) {
+	viper.Set("builders", builders)
+	t.Cleanup(func() { viper.Set("builders", nil) })
+}
+
+// builtinBuilders returns options with the builtin builders, as configured by Validate.
+func builtinBuilders() *ResolveOptions {
+	opts := &ResolveOptions{}
+	opts.builders = map[string]builder{
+		"dockerfile": opts.db,
+		"buildpack":  opts.bp,
+		"ko":         opts.ko,
+	}
+	return opts
+}
+
+func TestCustomBuilders(t *testing.T) {
+	tests := []struct {
+		name     string
+		builders interface{}
+		want     []string
+		wantErr  string
+	}{{
+		name: "none",
+	}, {
+		name: "task and bundle",
+		builders: map[string]interface{}{
+			"jib":   map[string]interface{}{"task": "hack/jib.yaml"},
+			"bazel": map[string]interface{}{"bundle": "gcr.io/foo/builders:v1", "name": "bazel", "params": map[string]interface{}{"TARGET": "//cmd/app:image"}},
+		},
+		want: []string{"bazel", "jib"},
+	}, {
+		name:     "reserved dockerfile",
+		builders: map[string]interface{}{"dockerfile": map[string]interface{}{"task": "hack/jib.yaml"}},
+		wantErr:  "invalid key name \"dockerfile\": builders",
+	}, {
+		name:     "reserved buildpack",
+		builders: map[string]interface{}{"buildpack": map[string]interface{}{"task": "hack/jib.yaml"}},
+		wantErr:  "invalid key name \"buildpack\": builders",
+	}, {
+		name:     "reserved ko",
+		builders: map[string]interface{}{"ko": map[string]interface{}{"task": "hack/jib.yaml"}},
+		wantErr:  "invalid key name \"ko\": builders",
+	}, {
+		name:     "invalid scheme",
+		builders: map[string]interface{}{"Jib_Builder": map[string]interface{}{"task": "hack/jib.yaml"}},
+		// viper lowercases the keys.
+		wantErr: "invalid key name \"jib_builder\": builders",
+	}, {
+		name:     "neither task nor bundle",
+		builders: map[string]interface{}{"jib": map[string]interface{}{"name": "jib"}},
+		wantErr:  "expected exactly one, got neither: builders.jib.bundle, builders.jib.task",
+	}, {
+		name:     "both task and bundle",
+		builders: map[string]interface{}{"jib": map[string]interface{}{"task": "hack/jib.yaml", "bundle": "gcr.io/foo/builders:v1"}},
+		wantErr:  "expected exactly one, got both: builders.jib.bundle, builders.jib.task",
+	}, {
+		name:     "invalid bundle",
+		builders: map[string]interface{}{"jib": map[string]interface{}{"bundle": "Not An Image"}},
+		wantErr:  "builders.jib.bundle",
+	}, {
+		name:     "malformed",
+		builders: []interface{}{"jib"},
+		wantErr:  "invalid value",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withBuilders(t, test.builders)
+
+			got, err := builtinBuilders().customBuilders()
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			schemes := make([]string, 0, len(got))
+			for scheme := range got {
+				schemes = append(schemes, scheme)
+			}
+			assert.ElementsMatch(t, test.want, schemes)
+		})
+	}
+}
+
+func TestCustomBuilderLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	taskFile := filepath.Join(dir, "echo.yaml")
+
+	withBuilders(t, map[string]interface{}{
+		"echo":    map[string]interface{}{"task": taskFile, "params": map[string]interface{}{"MESSAGE": "hello"}},
+		"missing": map[string]interface{}{"task": filepath.Join(dir, "missing.yaml")},
+	})
+	opts := builtinBuilders()
+	opts.Directory = dir
+	opts.ImageName = "gcr.io/foo/$DIR_NAME"
+	opts.cmd = &cobra.Command{}
+	opts.cmd.SetOut(ioutil.Discard)
+
+	// Nothing is loaded until the builders are used.
+	builders, err := opts.customBuilders()
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(taskFile, []byte(echoTask), 0644))
+
+	u, err := url.Parse("echo:///app?MESSAGE=world")
+	require.NoError(t, err)
+	plan, err := builders["echo"](context.TODO(), nil, u)
+	require.NoError(t, err)
+	assert.Equal(t, "gcr.io/foo/app", plan.imageName)
+	require.Len(t, plan.tr.Spec.TaskSpec.Steps, 1)
+	assert.Contains(t, plan.tr.Spec.TaskSpec.Steps[0].Script, `echo "world"`, "the query overrides the params")
+
+	// The Task is only loaded once.
+	require.NoError(t, os.Remove(taskFile))
+	u, err = url.Parse("echo:///other")
+	require.NoError(t, err)
+	plan, err = builders["echo"](context.TODO(), nil, u)
+	require.NoError(t, err)
+	assert.Contains(t, plan.tr.Spec.TaskSpec.Steps[0].Script, `echo "hello"`)
+
+	// As are failures to load it.
+	u, err = url.Parse("missing:///app")
+	require.NoError(t, err)
+	_, err = builders["missing"](context.TODO(), nil, u)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "missing.yaml"), []byte(echoTask), 0644))
+	_, err = builders["missing"](context.TODO(), nil, u)
+	require.Error(t, err, "the error is remembered")
+	assert.Contains(t, err.Error(), "failed to read")
+}
+
+func TestCustomBuilderRelativeTask(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// Resolve symlinks (e.g. of /tmp on macOS), as the working directory does.
+	dir, err = filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "hack"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "app"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "hack", "echo.yaml"), []byte(echoTask), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, minkFileName),
+		[]byte("builders:\n  echo:\n    task: hack/echo.yaml\n"), 0644))
+	withBuilders(t, map[string]interface{}{
+		"echo": map[string]interface{}{"task": "hack/echo.yaml"},
+	})
+
+	// Run from a subdirectory, whose nearest .mink.yaml is the one in dir.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(filepath.Join(dir, "app")))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	opts := builtinBuilders()
+	opts.Directory = "."
+	opts.ImageName = "gcr.io/foo/$DIR_NAME"
+	opts.cmd = &cobra.Command{}
+	opts.cmd.SetOut(ioutil.Discard)
+
+	builders, err := opts.customBuilders()
+	require.NoError(t, err)
+	u, err := url.Parse("echo:///app?MESSAGE=hello")
+	require.NoError(t, err)
+	plan, err := builders["echo"](context.TODO(), nil, u)
+	require.NoError(t, err, "the Task file is relative to the .mink.yaml")
+	assert.Contains(t, plan.tr.Spec.TaskSpec.Steps[0].Script, `echo "hello"`)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	errs "github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// dirSettings maps the settings that the .mink.yaml in the directory of a reference
+// may hold for its build onto the query parameters of references of each scheme,
+// through which the reference itself may override them.  Settings of the other
+// schemes, or that apply to the whole resolve, are ignored.
+var dirSettings = map[string]map[string]string{
+	"dockerfile": {
+		"dockerfile":   "dockerfile",
+		"engine":       "engine",
+		"target":       "target",
+		"build-arg":    "arg",
+		"image-label":  "label",
+		"build-secret": "secret",
+	},
+	"buildpack": {
+		"builder":   "builder",
+		"run-image": "run-image",
+		"env":       "env",
+	},
+	"ko": {
+		"ko-base-image": "base-image",
+	},
+}
+
+// dirConfig returns the query parameters of the reference, merged over the settings
+// of the .mink.yaml in its directory (if it has its own), along with the image
+// (which defaults to --image) to which to publish it.
+func (opts *ResolveOptions) dirConfig(u *url.URL) (url.Values, string, error) {
+	q, image := u.Query(), opts.ImageName
+	if filepath.Clean("/"+u.Path) == "/" {
+		// The root config has already been applied.
+		return q, image, nil
+	}
+
+	file := filepath.Join(opts.Directory, u.Path, minkFileName)
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return q, image, nil
+	} else if err != nil {
+		return nil, "", errs.Wrapf(err, "failed to read %s", file)
+	}
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, "", errs.Wrapf(err, "failed to parse %s", file)
+	}
+
+	if s, ok := settings["image"]; ok {
+		image = fmt.Sprint(s)
+	}
+	for setting, param := range dirSettings[u.Scheme] {
+		// The last of the values of single-valued parameters wins, as does
+		// that of each KEY=VALUE key, so the query's come last.
+		if value, ok := settings[setting]; ok {
+			q[param] = append(settingValues(value), q[param]...)
+		}
+	}
+	return q, image, nil
+}
+
+// koDir returns the directory (relative to --directory) of the package with the
+// import path, when it belongs to the Go module at the root of --directory.
+func (opts *ResolveOptions) koDir(importPath string) (string, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(opts.Directory, "go.mod"))
+	if err != nil {
+		return "", false
+	}
+	module := modulePath(data)
+	switch {
+	case module == "":
+		return "", false
+	case importPath == module:
+		return "/", true
+	case strings.HasPrefix(importPath, module+"/"):
+		return strings.TrimPrefix(importPath, module), true
+	default:
+		return "", false
+	}
+}
+
+// modulePath returns the module path declared by the go.mod, or empty if it has none.
+func modulePath(gomod []byte) string {
+	for _, line := range strings.Split(string(gomod), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "module" {
+			continue
+		}
+		if path, err := strconv.Unquote(fields[1]); err == nil {
+			return path
+		}
+		return fields[1]
+	}
+	return ""
+}
+
+// settingValues returns the values of a setting, which may be a single value, a list
+// of values, or (for KEY=VALUE settings) a map.
+func settingValues(value interface{}) []string {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			values = append(values, fmt.Sprint(e))
+		}
+		return values
+	case map[string]interface{}:
+		values := make([]string, 0, len(v))
+		for k, e := range v {
+			values = append(values, fmt.Sprintf("%s=%v", k, e))
+		}
+		sort.Strings(values)
+		return values
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
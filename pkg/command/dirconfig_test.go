@@ -0,0 +1,243 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattmoor/mink/pkg/builds/dockerfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles writes the files (keyed by their path relative to dir).
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestDirConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		// The root config is applied to the flags instead.
+		minkFileName: "image: gcr.io/root/$DIR_NAME\nengine: buildah\n",
+		"svc/" + minkFileName: `
+image: gcr.io/svc/$DIR_NAME
+engine: buildkit
+build-arg:
+  VERSION: "2.0"
+  DEBUG: true
+image-label: [team=b]
+builder: paketobuildpacks/builder:base
+unknown: ignored
+`,
+		"app/" + minkFileName: "builder: paketobuildpacks/builder:base\nenv: [BP_JVM_VERSION=17]\nengine: buildkit\n",
+		"bad/" + minkFileName: "engine: [",
+	})
+
+	opts := &ResolveOptions{}
+	opts.Directory = dir
+	opts.ImageName = "gcr.io/flag/$DIR_NAME"
+
+	tests := []struct {
+		name      string
+		ref       string
+		wantQuery url.Values
+		wantImage string
+		wantErr   string
+	}{{
+		name:      "root",
+		ref:       "dockerfile:///?target=prod",
+		wantQuery: url.Values{"target": {"prod"}},
+		wantImage: "gcr.io/flag/$DIR_NAME",
+	}, {
+		name:      "no config",
+		ref:       "dockerfile:///other?target=prod",
+		wantQuery: url.Values{"target": {"prod"}},
+		wantImage: "gcr.io/flag/$DIR_NAME",
+	}, {
+		name: "nested config beneath the query",
+		ref:  "dockerfile:///svc?engine=kaniko&arg=VERSION=3.0",
+		wantQuery: url.Values{
+			"engine": {"buildkit", "kaniko"},
+			"arg":    {"DEBUG=true", "VERSION=2.0", "VERSION=3.0"},
+			"label":  {"team=b"},
+		},
+		wantImage: "gcr.io/svc/$DIR_NAME",
+	}, {
+		name: "settings of other schemes are ignored",
+		ref:  "buildpack:///app",
+		wantQuery: url.Values{
+			"builder": {"paketobuildpacks/builder:base"},
+			"env":     {"BP_JVM_VERSION=17"},
+		},
+		wantImage: "gcr.io/flag/$DIR_NAME",
+	}, {
+		name:    "invalid config",
+		ref:     "dockerfile:///bad",
+		wantErr: "failed to parse",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			u, err := url.Parse(test.ref)
+			require.NoError(t, err)
+
+			q, image, err := opts.dirConfig(u)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.wantQuery, q)
+			assert.Equal(t, test.wantImage, image)
+		})
+	}
+}
+
+func TestDirConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"svc/" + minkFileName: "engine: buildkit\ntarget: nested\nbuild-arg: [VERSION=2.0, DEBUG=true]\n",
+	})
+
+	opts := &ResolveOptions{}
+	opts.Directory = dir
+
+	// The flags (and root config) come first, then the nested config, then the query.
+	flags := dockerfile.Options{
+		Dockerfile: "Dockerfile",
+		Engine:     "kaniko",
+		Target:     "flag",
+		BuildArgs:  map[string]string{"VERSION": "1.0", "FROM_FLAG": "yes"},
+	}
+	u, err := url.Parse("dockerfile:///svc?target=query&arg=DEBUG=false")
+	require.NoError(t, err)
+	q, _, err := opts.dirConfig(u)
+	require.NoError(t, err)
+	got, err := dockerfileQuery(flags, q)
+	require.NoError(t, err)
+
+	assert.Equal(t, dockerfile.Options{
+		Dockerfile: "Dockerfile",
+		Engine:     "buildkit",
+		Target:     "query",
+		BuildArgs:  map[string]string{"VERSION": "2.0", "DEBUG": "false", "FROM_FLAG": "yes"},
+	}, got)
+}
+
+func TestKoDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := &ResolveOptions{}
+	opts.Directory = dir
+
+	_, ok := opts.koDir("github.com/foo/bar/cmd/baz")
+	assert.False(t, ok, "without a go.mod")
+
+	writeFiles(t, dir, map[string]string{
+		"go.mod":                  "// The module.\nmodule github.com/foo/bar\n\ngo 1.14\n",
+		"cmd/baz/" + minkFileName: "image: gcr.io/baz/$DIR_NAME\nko-base-image: gcr.io/distroless/base\nengine: buildkit\n",
+	})
+
+	tests := []struct {
+		importPath string
+		want       string
+		wantOK     bool
+	}{{
+		importPath: "github.com/foo/bar",
+		want:       "/",
+		wantOK:     true,
+	}, {
+		importPath: "github.com/foo/bar/cmd/baz",
+		want:       "/cmd/baz",
+		wantOK:     true,
+	}, {
+		importPath: "github.com/foo/barista/cmd/baz",
+	}, {
+		importPath: "github.com/other/dep",
+	}}
+	for _, test := range tests {
+		t.Run(test.importPath, func(t *testing.T) {
+			got, ok := opts.koDir(test.importPath)
+			assert.Equal(t, test.wantOK, ok)
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	// The package's config applies to its ko:// reference.
+	q, image, err := opts.dirConfig(&url.URL{Scheme: "ko", Path: "/cmd/baz", RawQuery: "naming=bare"})
+	require.NoError(t, err)
+	assert.Equal(t, "gcr.io/baz/$DIR_NAME", image)
+	assert.Equal(t, url.Values{"base-image": {"gcr.io/distroless/base"}, "naming": {"bare"}}, q)
+}
+
+func TestModulePath(t *testing.T) {
+	tests := map[string]string{
+		"module github.com/foo/bar\n":               "github.com/foo/bar",
+		"module \"github.com/foo/bar\" // quoted\n": "github.com/foo/bar",
+		"\n  module   example.com/x\ngo 1.14\n":     "example.com/x",
+		"go 1.14\n":                                 "",
+		"":                                          "",
+	}
+	for gomod, want := range tests {
+		assert.Equal(t, want, modulePath([]byte(gomod)), gomod)
+	}
+}
+
+func TestSettingValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []string
+	}{{
+		name:  "scalar",
+		value: "buildkit",
+		want:  []string{"buildkit"},
+	}, {
+		name:  "number",
+		value: 17,
+		want:  []string{"17"},
+	}, {
+		name:  "list",
+		value: []interface{}{"VERSION=1.0", true},
+		want:  []string{"VERSION=1.0", "true"},
+	}, {
+		name:  "map",
+		value: map[string]interface{}{"VERSION": "1.0", "DEBUG": false},
+		want:  []string{"DEBUG=false", "VERSION=1.0"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, settingValues(test.value))
+		})
+	}
+}
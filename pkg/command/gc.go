@@ -0,0 +1,120 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	tektonclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/signals"
+)
+
+var gcExample = fmt.Sprintf(`
+  # Delete everything left behind by builds more than an hour ago, across all namespaces.
+  %[1]s gc
+
+  # List what was left behind by builds more than ten minutes ago, without deleting it.
+  %[1]s gc --older-than 10m --dry-run
+
+  # Only clean up the namespace "builds".
+  %[1]s gc --namespace builds`, ExamplePrefix())
+
+// NewGCCommand implements 'kn-im gc' command
+func NewGCCommand() *cobra.Command {
+	opts := &GCOptions{}
+
+	cmd := &cobra.Command{
+		Use:     "gc",
+		Short:   "Cleans up the TaskRuns, pods, Secrets and ServiceAccounts left behind by builds that were interrupted.",
+		Example: gcExample,
+		PreRunE: opts.Validate,
+		RunE:    opts.Execute,
+	}
+
+	opts.AddFlags(cmd)
+
+	return cmd
+}
+
+// GCOptions implements Interface for the `kn im gc` command.
+type GCOptions struct {
+	// Namespace is the namespace to clean up, or empty for all namespaces.
+	Namespace string
+
+	// OlderThan is how old the leftovers must be for us to clean them up.
+	OlderThan time.Duration
+
+	// DryRun lists the leftovers rather than deleting them.
+	DryRun bool
+}
+
+// GCOptions implements Interface
+var _ Interface = (*GCOptions)(nil)
+
+// AddFlags implements Interface
+func (opts *GCOptions) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().String("namespace", "", "The namespace to clean up, defaults to all namespaces.")
+	cmd.Flags().Duration("older-than", time.Hour, "How old the leftovers of builds must be to be cleaned up.")
+	cmd.Flags().Bool("dry-run", false, "List the leftovers of builds instead of deleting them.")
+}
+
+// Validate implements Interface
+func (opts *GCOptions) Validate(cmd *cobra.Command, args []string) error {
+	viper.BindPFlags(cmd.Flags())
+
+	opts.Namespace = viper.GetString("namespace")
+	opts.OlderThan = viper.GetDuration("older-than")
+	if opts.OlderThan < 0 {
+		return apis.ErrInvalidValue(opts.OlderThan, "older-than")
+	}
+	opts.DryRun = viper.GetBool("dry-run")
+	return nil
+}
+
+// Execute implements Interface
+func (opts *GCOptions) Execute(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("'im gc' does not take any arguments")
+	}
+
+	cfg, err := builds.GetConfig("", "")
+	if err != nil {
+		return err
+	}
+	client, err := tektonclientset.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	kubeclient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	return builds.GarbageCollect(signals.NewContext(), client, kubeclient, builds.GCOptions{
+		Namespace: opts.Namespace,
+		OlderThan: opts.OlderThan,
+		DryRun:    opts.DryRun,
+		Now:       time.Now(),
+		Out:       cmd.OutOrStdout(),
+	})
+}
@@ -86,6 +86,14 @@ func TestCommandPackage(t *testing.T) {
 				filepath.Join(chartTemplatesDir, "helloworld-php-service.yaml"),
 			},
 		},
+		{
+			name:           "ko",
+			image:          "gcr.io/jenkins-x-labs-bdd/$DIR_NAME:latest",
+			filenames:      []string{filepath.Join("helloworld-go", "service.yaml")},
+			args:           []string{"--output", filepath.Join(tmpDir, "ko"), "--local-ko", "--ko-binary", filepath.Join("test_data", "ko.sh")},
+			resolvePath:    []string{"spec", "template", "spec", "containers", "[name=main]", "image"},
+			expectedImages: []string{"gcr.io/jenkins-x-labs-bdd/helloworld-go:latest@" + expectedDigest},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -0,0 +1,200 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"knative.dev/pkg/apis"
+	"sigs.k8s.io/yaml"
+)
+
+// podTemplateOptions holds the controls over the pods in which builds run.
+type podTemplateOptions struct {
+	// PodTemplate holds the scheduling controls to merge into the TaskRuns' pod template.
+	PodTemplate tknv1beta1.PodTemplate
+
+	// Resources are the resource requirements for the steps of every builder.
+	Resources corev1.ResourceRequirements
+
+	// BuilderResources are the resource requirements for the steps of particular
+	// builders (e.g. dockerfile, buildpack or ko), which take precedence over Resources.
+	BuilderResources map[string]corev1.ResourceRequirements
+}
+
+// AddFlags implements Interface
+func (opts *podTemplateOptions) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("node-selector", nil,
+		"A KEY=VALUE node label that build pods must be scheduled onto, may be repeated.")
+	cmd.Flags().StringSlice("toleration", nil,
+		"A taint that build pods tolerate in the form KEY[=VALUE]:EFFECT (the effect may be empty to tolerate all effects), may be repeated.")
+	cmd.Flags().String("priority-class", "", "The name of the PriorityClass for build pods.")
+	cmd.Flags().String("runtime-class", "", "The name of the RuntimeClass for build pods.")
+	cmd.Flags().String("affinity", "", "The affinity of build pods, as inline YAML or JSON.")
+	cmd.Flags().StringSlice("requests", nil,
+		"A NAME=QUANTITY resource request (e.g. cpu=1) for build steps, may be repeated. Per-builder requests may be configured under 'resources' in .mink.yaml.")
+	cmd.Flags().StringSlice("limits", nil,
+		"A NAME=QUANTITY resource limit (e.g. memory=4Gi) for build steps, may be repeated. Per-builder limits may be configured under 'resources' in .mink.yaml.")
+}
+
+// Validate implements Interface
+func (opts *podTemplateOptions) Validate(cmd *cobra.Command, args []string) error {
+	opts.PodTemplate = tknv1beta1.PodTemplate{}
+	for _, kv := range viper.GetStringSlice("node-selector") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return apis.ErrInvalidValue(kv, "node-selector")
+		}
+		if opts.PodTemplate.NodeSelector == nil {
+			opts.PodTemplate.NodeSelector = make(map[string]string, 1)
+		}
+		opts.PodTemplate.NodeSelector[parts[0]] = parts[1]
+	}
+
+	for _, t := range viper.GetStringSlice("toleration") {
+		toleration, err := parseToleration(t)
+		if err != nil {
+			return apis.ErrInvalidValue(err.Error(), "toleration")
+		}
+		opts.PodTemplate.Tolerations = append(opts.PodTemplate.Tolerations, toleration)
+	}
+
+	if pc := viper.GetString("priority-class"); pc != "" {
+		opts.PodTemplate.PriorityClassName = &pc
+	}
+	if rc := viper.GetString("runtime-class"); rc != "" {
+		opts.PodTemplate.RuntimeClassName = &rc
+	}
+
+	if viper.IsSet("affinity") {
+		affinity := &corev1.Affinity{}
+		if err := decodeConfig(viper.Get("affinity"), affinity); err != nil {
+			return apis.ErrInvalidValue(err.Error(), "affinity")
+		}
+		if (*affinity != corev1.Affinity{}) {
+			opts.PodTemplate.Affinity = affinity
+		}
+	}
+
+	var err error
+	if opts.Resources.Requests, err = parseResourceList(viper.GetStringSlice("requests")); err != nil {
+		return apis.ErrInvalidValue(err.Error(), "requests")
+	}
+	if opts.Resources.Limits, err = parseResourceList(viper.GetStringSlice("limits")); err != nil {
+		return apis.ErrInvalidValue(err.Error(), "limits")
+	}
+
+	// Per-builder resources may only be configured in .mink.yaml, e.g.
+	//   resources:
+	//     buildpack:
+	//       requests:
+	//         memory: 4Gi
+	opts.BuilderResources = nil
+	if viper.IsSet("resources") {
+		if err := decodeConfig(viper.Get("resources"), &opts.BuilderResources); err != nil {
+			return apis.ErrInvalidValue(err.Error(), "resources")
+		}
+	}
+	return nil
+}
+
+// option returns the builds.CancelableOption applying the pod template controls to
+// the TaskRuns of the named builder.
+func (opts *podTemplateOptions) option(builder string) builds.CancelableOption {
+	resources := *opts.Resources.DeepCopy()
+	if br, ok := opts.BuilderResources[builder]; ok {
+		for k, v := range br.Requests {
+			if resources.Requests == nil {
+				resources.Requests = corev1.ResourceList{}
+			}
+			resources.Requests[k] = v
+		}
+		for k, v := range br.Limits {
+			if resources.Limits == nil {
+				resources.Limits = corev1.ResourceList{}
+			}
+			resources.Limits[k] = v
+		}
+	}
+	return builds.WithPodTemplate(opts.PodTemplate, resources)
+}
+
+// parseToleration parses a toleration in the form KEY[=VALUE]:EFFECT, tolerating
+// taints with any value when VALUE is omitted, and with any effect when EFFECT is.
+func parseToleration(s string) (corev1.Toleration, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return corev1.Toleration{}, fmt.Errorf("expected KEY[=VALUE]:EFFECT, got: %s", s)
+	}
+	toleration := corev1.Toleration{
+		Key:      s[:i],
+		Operator: corev1.TolerationOpExists,
+		Effect:   corev1.TaintEffect(s[i+1:]),
+	}
+	if parts := strings.SplitN(toleration.Key, "=", 2); len(parts) == 2 {
+		toleration.Key, toleration.Value = parts[0], parts[1]
+		toleration.Operator = corev1.TolerationOpEqual
+	}
+	switch toleration.Effect {
+	case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		return corev1.Toleration{}, fmt.Errorf("unsupported taint effect in %s: %s", s, toleration.Effect)
+	}
+	return toleration, nil
+}
+
+// parseResourceList parses NAME=QUANTITY pairs into a ResourceList.
+func parseResourceList(kvs []string) (corev1.ResourceList, error) {
+	if len(kvs) == 0 {
+		return nil, nil
+	}
+	rl := make(corev1.ResourceList, len(kvs))
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("expected NAME=QUANTITY, got: %s", kv)
+		}
+		q, err := resource.ParseQuantity(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity for %s: %w", parts[0], err)
+		}
+		rl[corev1.ResourceName(parts[0])] = q
+	}
+	return rl, nil
+}
+
+// decodeConfig decodes a structured value from viper (either inline YAML/JSON passed
+// as a flag or environment variable, or a nested object in .mink.yaml) into out.
+func decodeConfig(value interface{}, out interface{}) error {
+	var b []byte
+	if s, ok := value.(string); ok {
+		b = []byte(s)
+	} else {
+		var err error
+		if b, err = yaml.Marshal(value); err != nil {
+			return err
+		}
+	}
+	return yaml.Unmarshal(b, out)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds/buildpacks"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/signals"
+)
+
+var rebaseExample = fmt.Sprintf(`
+  # Rebase an image built with buildpacks onto the latest run image of its stack,
+  # updating the tag and printing the new digest.
+  %[1]s rebase --image docker.io/mattmoor/app:latest
+
+  # As the first, but onto the provided run image.
+  %[1]s rebase --image docker.io/mattmoor/app:latest --run-image docker.io/paketobuildpacks/run:full-cnb`, ExamplePrefix())
+
+// NewRebaseCommand implements 'kn-im rebase' command
+func NewRebaseCommand() *cobra.Command {
+	opts := &RebaseOptions{}
+
+	cmd := &cobra.Command{
+		Use:     "rebase --image IMAGE",
+		Short:   "Swap the run image of an image built with buildpacks for a newer one, without rebuilding it.",
+		Example: rebaseExample,
+		PreRunE: opts.Validate,
+		RunE:    opts.Execute,
+	}
+
+	opts.AddFlags(cmd)
+
+	return cmd
+}
+
+// RebaseOptions implements Interface for the `kn im rebase` command.
+type RebaseOptions struct {
+	// Image is the image to rebase.
+	Image name.Reference
+
+	// RunImage is the run image onto which to rebase, which defaults
+	// to the run image of the stack with which the image was built.
+	RunImage string
+}
+
+// RebaseOptions implements Interface
+var _ Interface = (*RebaseOptions)(nil)
+
+// AddFlags implements Interface
+func (opts *RebaseOptions) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().String("image", "", "The image built with buildpacks to rebase, whose tag is updated.")
+	cmd.Flags().String("run-image", "", "The run image onto which to rebase, defaults to the run image of the image's stack.")
+}
+
+// Validate implements Interface
+func (opts *RebaseOptions) Validate(cmd *cobra.Command, args []string) error {
+	viper.BindPFlags(cmd.Flags())
+
+	// Read the flag directly, as the "image" in .mink.yaml configures our builds,
+	// and is not the image to rebase.
+	image, err := cmd.Flags().GetString("image")
+	if err != nil {
+		return err
+	}
+	if image == "" {
+		return apis.ErrMissingField("image")
+	}
+	if opts.Image, err = name.ParseReference(image, name.WeakValidation); err != nil {
+		return apis.ErrInvalidValue(err.Error(), "image")
+	}
+
+	opts.RunImage = viper.GetString("run-image")
+	if opts.RunImage != "" {
+		if _, err := name.ParseReference(opts.RunImage, name.WeakValidation); err != nil {
+			return apis.ErrInvalidValue(err.Error(), "run-image")
+		}
+	}
+	return nil
+}
+
+// Execute implements Interface
+func (opts *RebaseOptions) Execute(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("'im rebase' does not take any arguments")
+	}
+
+	digest, err := buildpacks.Rebase(signals.NewContext(), opts.Image, opts.RunImage)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", digest.String())
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebaseOptionsIgnoresConfiguredImage(t *testing.T) {
+	// The image with which .mink.yaml configures our builds.
+	viper.SetConfigType("yaml")
+	require.NoError(t, viper.ReadConfig(strings.NewReader("image: ko://github.com/mattmoor/mink/cmd/mink\n")))
+	t.Cleanup(func() {
+		viper.ReadConfig(strings.NewReader(""))
+	})
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{{
+		name: "flag",
+		args: []string{"--image", "gcr.io/foo/bar:latest"},
+		want: "gcr.io/foo/bar:latest",
+	}, {
+		name:    "no flag",
+		wantErr: "missing field(s): image",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &RebaseOptions{}
+			cmd := &cobra.Command{}
+			opts.AddFlags(cmd)
+			require.NoError(t, cmd.ParseFlags(test.args))
+
+			err := opts.Validate(cmd, nil)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, opts.Image.String())
+		})
+	}
+}
@@ -26,8 +26,8 @@ import (
 	"log"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -37,7 +37,6 @@ import (
 	"github.com/mattmoor/mink/pkg/builds/buildpacks"
 	"github.com/mattmoor/mink/pkg/builds/dockerfile"
 	"github.com/mattmoor/mink/pkg/builds/ko"
-	"github.com/mattmoor/mink/pkg/source"
 	errs "github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -45,7 +44,10 @@ import (
 	"github.com/tektoncd/cli/pkg/options"
 	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"knative.dev/pkg/apis"
 	"knative.dev/pkg/pool"
 	"knative.dev/pkg/signals"
@@ -85,7 +87,27 @@ func NewResolveCommand() *cobra.Command {
 	return cmd
 }
 
-type builder func(context.Context, []tknv1beta1.Step, []name.Reference, *url.URL) (name.Digest, error)
+type builder func(context.Context, []tknv1beta1.Step, *url.URL) (*buildPlan, error)
+
+// buildPlan is what a builder produces for a reference: the TaskRun that builds
+// the image, and how to execute it.
+type buildPlan struct {
+	// builder is the scheme of the builder (e.g. dockerfile) that planned the build.
+	builder string
+
+	imageName string
+	tag       name.Tag
+	tr        *tknv1beta1.TaskRun
+
+	// build produces the TaskRun that publishes the image to the provided tag, for
+	// builders that we run once per platform.  It is nil for builders that handle
+	// multiple platforms themselves (e.g. ko).
+	build func(name.Tag) (*tknv1beta1.TaskRun, error)
+
+	// local is whether to execute the TaskRun locally with the tooling.
+	local   bool
+	tooling builds.LocalExecutor
+}
 
 // ResolveOptions implements Interface for the `kn im resolve` command.
 type ResolveOptions struct {
@@ -102,8 +124,37 @@ type ResolveOptions struct {
 	// LocalKaniko invoke kaniko locally rather that using a TaskRun. Typically used inside a Pipeline
 	LocalKaniko bool
 
-	// KanikoBinary the kaniko binary to use if performing local builds
-	KanikoBinary string
+	// LocalBuildpacks invoke the buildpack lifecycle locally rather that using a TaskRun.
+	// Typically used inside a Pipeline step that runs in the builder image.
+	LocalBuildpacks bool
+
+	// LocalKo invoke ko locally rather that using a TaskRun. Typically used inside a Pipeline
+	LocalKo bool
+
+	// KoBinary the ko binary to use if performing local builds
+	KoBinary string
+
+	// KoBaseImage overrides the default base image of ko builds.
+	KoBaseImage string
+
+	// KoNaming is how ko names the images it publishes under the image's repository.
+	KoNaming string
+
+	// KoCachePVC and KoCacheImage are where to keep the Go build and module caches
+	// of ko builds across builds.
+	KoCachePVC   string
+	KoCacheImage string
+
+	// GoProxy and GoFlags are passed to the go toolchain of ko builds.
+	GoProxy string
+	GoFlags string
+
+	// Pipeline runs all of the in-cluster builds as a single PipelineRun, which
+	// fetches the source once into a shared workspace.
+	Pipeline bool
+
+	// PipelineStorage is the size of the volume claimed for the shared workspace.
+	PipelineStorage resource.Quantity
 
 	// AllowNoFiles with this setting it is valid to have no files to resolve
 	AllowNoFiles bool
@@ -144,7 +195,30 @@ func (opts *ResolveOptions) AddFlags(cmd *cobra.Command) {
 	cmd.Flags().Bool("flatten-output", false, "Put all of the output files into the output directory as files without a tree of directories. This is particularly useful if creating a Helm chart of all the YAML output files where you typically just want to put all the different YAML file names in, say, the charts/mychart/templates folder")
 	cmd.Flags().BoolP("local-kaniko", "L", false,
 		"Uses a local kaniko binary for building Dockerfile based builds instead of a separate TaskRun.")
-	cmd.Flags().StringP("kaniko-binary", "", "/kaniko/executor", "The kaniko/executor binary location if using local builds.")
+	cmd.Flags().Bool("local-buildpacks", false,
+		"Runs the buildpack lifecycle locally for buildpack:/// references instead of a separate TaskRun. Requires running within the builder image.")
+	cmd.Flags().Bool("local-ko", false,
+		"Uses a local ko binary (and go toolchain) for building ko:// references instead of a separate TaskRun.")
+	cmd.Flags().String("ko-binary", "ko", "The ko binary location if using local builds.")
+	cmd.Flags().String("ko-base-image", "",
+		"The default base image of ko:// references, overriding the defaultBaseImage of .ko.yaml (but not its baseImageOverrides).")
+	cmd.Flags().String("ko-cache-pvc", "",
+		"The PersistentVolumeClaim in which to keep the Go build and module caches of ko:// references across builds.")
+	cmd.Flags().String("ko-cache-image", "",
+		"The image in which to keep the Go build and module caches of ko:// references across builds.")
+	cmd.Flags().String("goproxy", "",
+		"The GOPROXY of ko:// references, which defaults to our own $GOPROXY.")
+	cmd.Flags().String("goflags", "",
+		"The GOFLAGS of ko:// references, which defaults to our own $GOFLAGS.")
+	cmd.Flags().Bool(ko.NamingBare, false,
+		"Publish ko:// references to the image's repository as it is (the default).")
+	cmd.Flags().Bool(ko.NamingBaseImportPaths, false,
+		"Publish ko:// references to the image's repository suffixed with the last element of their import path.")
+	cmd.Flags().Bool(ko.NamingPreserveImportPaths, false,
+		"Publish ko:// references to the image's repository suffixed with their full import path.")
+	cmd.Flags().Bool("pipeline", false,
+		"Runs all of the builds as a single PipelineRun, which fetches the source once into a shared workspace.")
+	cmd.Flags().String("pipeline-storage", "1Gi", "The size of the volume claimed for the shared workspace when using --pipeline.")
 }
 
 // Validate implements Interface
@@ -177,17 +251,64 @@ func (opts *ResolveOptions) Validate(cmd *cobra.Command, args []string) error {
 	}
 
 	opts.LocalKaniko = viper.GetBool("local-kaniko")
+	opts.LocalBuildpacks = viper.GetBool("local-buildpacks")
+	opts.LocalKo = viper.GetBool("local-ko")
+	opts.KoBinary = viper.GetString("ko-binary")
+	opts.KoBaseImage = viper.GetString("ko-base-image")
+	if opts.KoBaseImage != "" {
+		if _, err := name.ParseReference(opts.KoBaseImage, name.WeakValidation); err != nil {
+			return apis.ErrInvalidValue(err.Error(), "ko-base-image")
+		}
+	}
+	opts.KoCachePVC = viper.GetString("ko-cache-pvc")
+	opts.KoCacheImage = viper.GetString("ko-cache-image")
+	if opts.KoCachePVC != "" && opts.KoCacheImage != "" {
+		return apis.ErrMultipleOneOf("ko-cache-pvc", "ko-cache-image")
+	}
+	if opts.KoCacheImage != "" {
+		if _, err := name.NewTag(opts.KoCacheImage, name.WeakValidation); err != nil {
+			return apis.ErrInvalidValue(err.Error(), "ko-cache-image")
+		}
+	}
+	if opts.GoProxy = viper.GetString("goproxy"); opts.GoProxy == "" {
+		opts.GoProxy = os.Getenv("GOPROXY")
+	}
+	if opts.GoFlags = viper.GetString("goflags"); opts.GoFlags == "" {
+		opts.GoFlags = os.Getenv("GOFLAGS")
+	}
+	opts.KoNaming = ""
+	for _, naming := range ko.Namings {
+		if !viper.GetBool(naming) {
+			continue
+		}
+		if opts.KoNaming != "" {
+			return apis.ErrMultipleOneOf(ko.Namings...)
+		}
+		opts.KoNaming = naming
+	}
+
+	opts.Pipeline = viper.GetBool("pipeline")
+	storage, err := resource.ParseQuantity(viper.GetString("pipeline-storage"))
+	if err != nil {
+		return apis.ErrInvalidValue(viper.GetString("pipeline-storage"), "pipeline-storage")
+	}
+	opts.PipelineStorage = storage
 
 	opts.OutputDir = viper.GetString("output")
 	opts.FlattenOutput = viper.GetBool("flatten-output")
 
-	opts.KanikoBinary = viper.GetString("kaniko-binary")
-
 	opts.builders = map[string]builder{
 		"dockerfile": opts.db,
 		"buildpack":  opts.bp,
 		"ko":         opts.ko,
 	}
+	customBuilders, err := opts.customBuilders()
+	if err != nil {
+		return err
+	}
+	for scheme, b := range customBuilders {
+		opts.builders[scheme] = b
+	}
 
 	opts.cmd = cmd
 	return nil
@@ -207,7 +328,7 @@ func (opts *ResolveOptions) Execute(cmd *cobra.Command, args []string) error {
 // with apply (provides its own ctx)
 func (opts *ResolveOptions) execute(ctx context.Context, cmd *cobra.Command) error {
 	// Bundle up the source context in an image or use git clone to get the source.
-	sourceSteps, nameRefs, err := source.CreateSourceSteps(ctx, opts.Directory, opts.BundleOptions.tag, opts.BundleOptions.GitLocation)
+	sourceSteps, nameRefs, err := opts.sourceSteps(ctx)
 	if err != nil {
 		return err
 	}
@@ -227,6 +348,10 @@ func (opts *ResolveOptions) execute(ctx context.Context, cmd *cobra.Command) err
 	if err := opts.ResolveReferences(ctx, fileBlocks, sourceSteps, nameRefs); err != nil {
 		return err
 	}
+	if opts.DryRun {
+		// Nothing was built, so there is nothing to resolve.
+		return nil
+	}
 
 	// Encode the resulting yaml
 	for _, fb := range fileBlocks {
@@ -383,13 +508,9 @@ func (opts *ResolveOptions) ResolveReferences(ctx context.Context, fileBlocks []
 		}
 	}
 
-	errg, ctx := pool.NewWithContext(ctx, opts.Parallelism, opts.Parallelism)
-
-	// Next, perform parallel builds for each of the supported references.
-	var sm sync.Map
+	// Next, plan the build for each of the supported references.
+	plans := make(map[string]*buildPlan, len(refs))
 	for ref := range refs {
-		ref := ref
-
 		// Parse the reference and use the scheme to determine
 		// the builder to apply.
 		u, err := url.Parse(ref)
@@ -400,17 +521,56 @@ func (opts *ResolveOptions) ResolveReferences(ctx context.Context, fileBlocks []
 		if !ok {
 			continue
 		}
+		plan, err := builder(ctx, sourceSteps, u)
+		if err != nil {
+			return err
+		}
+		plan.builder = u.Scheme
+		plan.tr.Namespace = Namespace()
+		plans[ref] = plan
+	}
+
+	errg, ctx := pool.NewWithContext(ctx, opts.Parallelism, opts.Parallelism)
+
+	// Then perform parallel builds for each of the references, deferring the
+	// in-cluster builds to a single PipelineRun when asked to.
+	var sm, failed sync.Map
+	pipelined := make(map[string]*buildPlan, len(plans))
+	for ref, plan := range plans {
+		ref, plan := ref, plan
+		// Builds for multiple platforms are assembled into an index outside of the pipeline.
+		if opts.Pipeline && !plan.local && (len(opts.Platforms) <= 1 || plan.build == nil) {
+			pipelined[ref] = plan
+			continue
+		}
 
 		errg.Go(func() error {
-			digest, err := builder(ctx, sourceSteps, nameRefs, u)
+			digest, err := opts.build(ctx, plan, sourceSteps, nameRefs)
 			if err != nil {
+				failed.Store(ref, err)
 				return err
 			}
 			sm.Store(ref, digest.String())
 			return nil
 		})
 	}
+	if len(pipelined) > 0 {
+		errg.Go(func() error {
+			digests, err := opts.buildPipeline(ctx, pipelined, sourceSteps, nameRefs)
+			if err != nil {
+				for ref := range pipelined {
+					failed.Store(ref, err)
+				}
+				return err
+			}
+			for ref, digest := range digests {
+				sm.Store(ref, digest.String())
+			}
+			return nil
+		})
+	}
 	if err := errg.Wait(); err != nil {
+		opts.reportAborted(plans, &sm, &failed)
 		return err
 	}
 
@@ -429,71 +589,115 @@ func (opts *ResolveOptions) ResolveReferences(ctx context.Context, fileBlocks []
 	return nil
 }
 
-func (opts *ResolveOptions) db(ctx context.Context, sourceSteps []tknv1beta1.Step, nameRefs []name.Reference, u *url.URL) (name.Digest, error) {
+func (opts *ResolveOptions) db(ctx context.Context, sourceSteps []tknv1beta1.Step, u *url.URL) (*buildPlan, error) {
 	if u.Host != "" {
-		return name.Digest{}, fmt.Errorf(
+		return nil, fmt.Errorf(
 			"unexpected host in %q reference, got: %s (did you mean %s:/// instead of %s://?)",
 			u.Scheme, u.Host, u.Scheme, u.Scheme)
 	}
 
-	// TODO(mattmoor): Consider merging in some "path"-specific configuration here.
-	// My fundamental conflict is that I'd like for `mink buildpack` to be consistent,
-	// and they have different views of the filesystem (more will work here)...
-
 	path := u.Path
 
-	imageName, tag, err := opts.ResolveImageName(path)
+	q, image, err := opts.dirConfig(u)
 	if err != nil {
-		return name.Digest{}, err
+		return nil, err
 	}
-	fmt.Fprintf(opts.cmd.OutOrStdout(), "building image %s\n", imageName)
+	imageName, tag, err := opts.resolveImageName(image, path)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(opts.cmd.OutOrStderr(), "building image %s\n", imageName)
 
-	digestFile := dockerfile.DigestFile
-	if opts.LocalKaniko {
-		// lets make sure we have a tmp dir as it may not exist yet if inside, say, a kaniko image
-		tmpDir := os.TempDir()
-		err = os.MkdirAll(tmpDir, 0760)
-		if err != nil {
-			return name.Digest{}, errs.Wrapf(err, "failed to create temp dir %s", tmpDir)
+	dOpts, err := dockerfileQuery(opts.dockerfileOptions.options(path), q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %q reference: %w", u, err)
+	}
+
+	build := func(tag name.Tag) (*tknv1beta1.TaskRun, error) {
+		tr := dockerfile.Build(ctx, sourceSteps, tag, dOpts)
+		tr.Namespace = Namespace()
+		return tr, nil
+	}
+	tr, err := build(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &buildPlan{
+		imageName: imageName,
+		tag:       tag,
+		tr:        tr,
+		build:     build,
+		local:     opts.Local || opts.LocalKaniko,
+		tooling:   opts.dockerfileOptions.tooling(dOpts.Engine),
+	}, nil
+}
+
+// dockerfileQuery overrides the options with those in the query of a dockerfile:///
+// reference, e.g. dockerfile:///path?dockerfile=Dockerfile.prod&engine=buildkit&target=prod&arg=VERSION=1.0&label=team=x&secret=npm
+func dockerfileQuery(dOpts dockerfile.Options, q url.Values) (dockerfile.Options, error) {
+	merge := func(base map[string]string, kvs []string) (map[string]string, error) {
+		overrides, err := keyValues(kvs)
+		if err != nil || len(overrides) == 0 {
+			return base, err
 		}
-		tmpFile, err := ioutil.TempFile("", "mink-digest-")
-		if err != nil {
-			return name.Digest{}, errs.Wrapf(err, "failed to create temp digest file")
+		m := make(map[string]string, len(base)+len(overrides))
+		for k, v := range base {
+			m[k] = v
+		}
+		for k, v := range overrides {
+			m[k] = v
 		}
-		digestFile = tmpFile.Name()
+		return m, nil
 	}
-	tr := dockerfile.Build(ctx, sourceSteps, tag, dockerfile.Options{
-		Dockerfile: opts.Dockerfile,
-		Path:       path,
-		DigestFile: digestFile,
-		KanikoArgs: opts.KanikoArgs,
-	})
-	tr.Namespace = Namespace()
 
-	// Buffer the output, so we can display it on failures.
-	var buf *bytes.Buffer
-	var out io.Writer
-	if opts.OutputDir == "" {
-		buf = &bytes.Buffer{}
-		// lets only log output on errors so we can pipe the output to kubectl etc
-		out = buf
+	var err error
+	for key, values := range q {
+		switch key {
+		case "dockerfile":
+			if dOpts.Dockerfile = values[len(values)-1]; dOpts.Dockerfile == "" {
+				return dOpts, errors.New("empty dockerfile")
+			}
+		case "engine":
+			if dOpts.Engine = values[len(values)-1]; !sets.NewString(dockerfile.Engines...).Has(dOpts.Engine) {
+				return dOpts, fmt.Errorf("unsupported engine %q, expected one of %v", dOpts.Engine, dockerfile.Engines)
+			}
+		case "target":
+			dOpts.Target = values[len(values)-1]
+		case "arg":
+			if dOpts.BuildArgs, err = merge(dOpts.BuildArgs, values); err != nil {
+				return dOpts, err
+			}
+		case "label":
+			if dOpts.Labels, err = merge(dOpts.Labels, values); err != nil {
+				return dOpts, err
+			}
+		case "secret":
+			for _, secret := range values {
+				if problems := validation.IsDNS1123Subdomain(secret); len(problems) > 0 {
+					return dOpts, fmt.Errorf("invalid secret %q: %s", secret, strings.Join(problems, "; "))
+				}
+			}
+			dOpts.Secrets = append(append([]string{}, dOpts.Secrets...), values...)
+		default:
+			return dOpts, fmt.Errorf("unsupported parameter %q", key)
+		}
+	}
+	return dOpts, nil
+}
+
+// build runs the planned Build definition to completion (for each platform), returning
+// the digest of the produced image.
+func (opts *ResolveOptions) build(ctx context.Context, plan *buildPlan, sourceSteps []tknv1beta1.Step, nameRefs []name.Reference) (name.Digest, error) {
+	buf, out := opts.buildOutput()
+	executor := opts.executor(plan.builder, plan.local, sourceSteps, nameRefs, out, plan.tooling)
+	var digest name.Digest
+	var err error
+	if plan.build != nil {
+		digest, err = opts.executePlatforms(ctx, executor, plan.imageName, plan.tag, plan.build)
 	} else {
-		out = opts.cmd.OutOrStderr()
-	}
-
-	if opts.LocalKaniko {
-		return opts.runLocalBuild(tr, opts.KanikoBinary, imageName, path, digestFile)
-	}
-	// Run the produced Build definition to completion, streaming logs to stdout, and
-	// returning the digest of the produced image.
-	digest, err := builds.Run(ctx, imageName, tr, &options.LogOptions{
-		Params: &cli.TektonParams{},
-		Stream: &cli.Stream{
-			Out: out,
-			Err: out,
-		},
-		Follow: true,
-	}, builds.WithServiceAccount(opts.ServiceAccount, nameRefs...))
+		digest, err = executor.Execute(ctx, plan.imageName, plan.tr)
+	}
 	if err != nil {
 		if buf != nil {
 			log.Print(buf.String())
@@ -503,11 +707,143 @@ func (opts *ResolveOptions) db(ctx context.Context, sourceSteps []tknv1beta1.Ste
 	return digest, nil
 }
 
+// buildPipeline runs the planned Build definitions as a single PipelineRun, which
+// fetches the source once, returning the digests of the produced images keyed by
+// reference.
+func (opts *ResolveOptions) buildPipeline(ctx context.Context, plans map[string]*buildPlan, sourceSteps []tknv1beta1.Step, nameRefs []name.Reference) (map[string]name.Digest, error) {
+	if opts.DryRun {
+		ctx = builds.WithDryRun(ctx)
+	}
+
+	// Name the pipeline tasks deterministically after the (sorted) references.
+	refs := make([]string, 0, len(plans))
+	for ref := range plans {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	trs := make(map[string]*tknv1beta1.TaskRun, len(refs))
+	keys := make(map[string]string, len(refs))
+	for i, ref := range refs {
+		tr := plans[ref].tr
+		if len(opts.Platforms) == 1 && plans[ref].build != nil {
+			builds.SelectPlatform(tr, opts.Platforms[0])
+		}
+		for _, o := range opts.buildOptions(plans[ref].builder, nameRefs) {
+			cancel, err := o(ctx, tr)
+			if err != nil {
+				return nil, err
+			}
+			defer cancel()
+		}
+		key := fmt.Sprint("build-", i)
+		trs[key] = tr
+		keys[ref] = key
+	}
+
+	pr, err := builds.Pipeline(sourceSteps, trs, opts.PipelineStorage)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]string, len(refs))
+	if opts.DryRun {
+		var obj interface{} = pr
+		if opts.EmitTask {
+			if obj, err = builds.ToPipeline(pr); err != nil {
+				return nil, err
+			}
+		} else {
+			pr.TypeMeta = metav1.TypeMeta{
+				APIVersion: tknv1beta1.SchemeGroupVersion.String(),
+				Kind:       "PipelineRun",
+			}
+		}
+		if err := builds.Print(opts.dryRunOut, opts.DryRunOutput, obj); err != nil {
+			return nil, err
+		}
+		// Nothing was built, so use placeholder digests.
+		for key := range trs {
+			results[key] = "sha256:" + strings.Repeat("0", 64)
+		}
+	} else {
+		buf, out := opts.buildOutput()
+		results, err = builds.RunPipeline(ctx, pr, &options.LogOptions{
+			Params: &cli.TektonParams{},
+			Stream: &cli.Stream{
+				Out: out,
+				Err: out,
+			},
+			Follow: true,
+		})
+		if err != nil {
+			if buf != nil {
+				log.Print(buf.String())
+			}
+			return nil, err
+		}
+	}
+
+	digests := make(map[string]name.Digest, len(refs))
+	for _, ref := range refs {
+		result, ok := results[keys[ref]]
+		if !ok {
+			return nil, fmt.Errorf("PipelineRun did not produce a digest for %q", ref)
+		}
+		digest, err := name.NewDigest(plans[ref].imageName + "@" + result)
+		if err != nil {
+			return nil, err
+		}
+		digests[ref] = digest
+	}
+	return digests, nil
+}
+
+// reportAborted lists the planned builds that did not complete because they were
+// interrupted, either by the user or by the failure of another build.
+func (opts *ResolveOptions) reportAborted(plans map[string]*buildPlan, succeeded, failed *sync.Map) {
+	aborted := make([]string, 0, len(plans))
+	for ref := range plans {
+		if _, ok := succeeded.Load(ref); ok {
+			continue
+		}
+		if err, ok := failed.Load(ref); ok && !errors.Is(err.(error), context.Canceled) {
+			continue
+		}
+		aborted = append(aborted, ref)
+	}
+	if len(aborted) == 0 {
+		return
+	}
+	sort.Strings(aborted)
+	fmt.Fprintf(opts.cmd.OutOrStderr(), "aborted %d of %d builds:\n", len(aborted), len(plans))
+	for _, ref := range aborted {
+		fmt.Fprintf(opts.cmd.OutOrStderr(), "  %s (%s)\n", ref, plans[ref].imageName)
+	}
+}
+
+// buildOutput returns where to send the output of builds, along with the buffer
+// holding it when it should only be displayed on failures.
+func (opts *ResolveOptions) buildOutput() (*bytes.Buffer, io.Writer) {
+	if opts.OutputDir == "" {
+		// lets only log output on errors so we can pipe the output to kubectl etc
+		buf := &bytes.Buffer{}
+		return buf, buf
+	}
+	return nil, opts.cmd.OutOrStderr()
+}
+
 // ResolveImageName allows environment variables to be used in the image string along with expressions for the
 // current directory name
 func (opts *ResolveOptions) ResolveImageName(path string) (string, name.Tag, error) {
+	return opts.resolveImageName(opts.ImageName, path)
+}
+
+// resolveImageName expands the image string (e.g. from --image or the .mink.yaml
+// of the directory) as ResolveImageName does.
+func (opts *ResolveOptions) resolveImageName(image, path string) (string, name.Tag, error) {
 	_, dirName := filepath.Split(path)
-	image := os.Expand(opts.ImageName, func(name string) string {
+	image = os.Expand(image, func(name string) string {
 		answer := os.Getenv(name)
 		if answer == "" && name == "DIR_NAME" {
 			answer = dirName
@@ -521,149 +857,185 @@ func (opts *ResolveOptions) ResolveImageName(path string) (string, name.Tag, err
 	return image, tag, nil
 }
 
-func (opts *ResolveOptions) bp(ctx context.Context, sourceSteps []tknv1beta1.Step, nameRefs []name.Reference, u *url.URL) (name.Digest, error) {
+func (opts *ResolveOptions) bp(ctx context.Context, sourceSteps []tknv1beta1.Step, u *url.URL) (*buildPlan, error) {
 	if u.Host != "" {
-		return name.Digest{}, fmt.Errorf(
+		return nil, fmt.Errorf(
 			"unexpected host in %q reference, got: %s (did you mean %s:/// instead of %s://?)",
 			u.Scheme, u.Host, u.Scheme, u.Scheme)
 	}
 
-	// TODO(mattmoor): Consider merging in some "path"-specific configuration here.
-	// My fundamental conflict is that I'd like for `mink buildpack` to be consistent,
-	// and they have different views of the filesystem (more will work here)...
-
-	// Parse our KEY=VALUE from the Host/Path combo.
-	// TODO(mattmoor): What if this was a path to a project.toml with overrides instead?
-	// parts := strings.SplitN(u.Host+u.Path, "=", 2)
-	// if len(parts) != 2 {
-	// 	parts = append(parts, "")
-	// }
-	// key, value := parts[0], parts[1]
-
-	tr := buildpacks.Build(ctx, sourceSteps, opts.tag, buildpacks.Options{
-		Builder:      opts.Builder,
-		OverrideFile: opts.OverrideFile,
-		Path:         u.Path,
-		// Env: []corev1.EnvVar{{
-		// 	Name:  key,
-		// 	Value: value,
-		// }},
-	})
-	tr.Namespace = Namespace()
-
-	// Buffer the output, so we can display it on failures.
-	buf := &bytes.Buffer{}
-
-	// Run the produced Build definition to completion, streaming logs to stdout, and
-	// returning the digest of the produced image.
-	digest, err := builds.Run(ctx, opts.ImageName, tr, &options.LogOptions{
-		Params: &cli.TektonParams{},
-		Stream: &cli.Stream{
-			// Send Out to stderr so we can capture the digest for composition.
-			Out: buf,
-			Err: buf,
-		},
-		Follow: true,
-	}, builds.WithServiceAccount(opts.ServiceAccount, nameRefs...))
+	q, image, err := opts.dirConfig(u)
 	if err != nil {
-		log.Print(buf.String())
-		return name.Digest{}, err
+		return nil, err
 	}
-	return digest, nil
-}
-
-func (opts *ResolveOptions) ko(ctx context.Context, sourceSteps []tknv1beta1.Step, nameRefs []name.Reference, u *url.URL) (name.Digest, error) {
-	// TODO(mattmoor): Consider merging in some "path"-specific configuration here.
-	// My fundamental conflict is that I'd like for `mink buildpack` to be consistent,
-	// and they have different views of the filesystem (more will work here)...
-
-	tr := ko.Build(ctx, sourceSteps, opts.tag, ko.Options{
-		ImportPath: u.String(),
-	})
-	tr.Namespace = Namespace()
-
-	// Buffer the output, so we can display it on failures.
-	buf := &bytes.Buffer{}
-
-	// Run the produced Build definition to completion, streaming logs to stdout, and
-	// returning the digest of the produced image.
-	digest, err := builds.Run(ctx, opts.ImageName, tr, &options.LogOptions{
-		Params: &cli.TektonParams{},
-		Stream: &cli.Stream{
-			// Send Out to stderr so we can capture the digest for composition.
-			Out: buf,
-			Err: buf,
-		},
-		Follow: true,
-	}, builds.WithServiceAccount(opts.ServiceAccount, nameRefs...))
+	imageName, tag, err := opts.resolveImageName(image, u.Path)
 	if err != nil {
-		log.Print(buf.String())
-		return name.Digest{}, err
+		return nil, err
 	}
-	return digest, nil
-}
-
-func (opts *ResolveOptions) refsFromDoc(doc *yaml.Node) yit.Iterator {
-	ps := make([]yit.Predicate, 0, len(opts.builders))
+	fmt.Fprintf(opts.cmd.OutOrStderr(), "building image %s\n", imageName)
 
-	for k := range opts.builders {
-		ps = append(ps, yit.WithPrefix(k+"://"))
+	local := opts.Local || opts.LocalBuildpacks
+	bpOpts, err := opts.buildpackQuery(u.Path, q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %q reference: %w", u, err)
+	}
+	if local || opts.Pipeline {
+		// The source is already in our working directory, or the shared workspace.
+		bpOpts.AppDir = builds.WorkspaceDir
+	}
+	build := func(tag name.Tag) (*tknv1beta1.TaskRun, error) {
+		tr, err := buildpacks.Build(ctx, sourceSteps, tag, bpOpts)
+		if err != nil {
+			return nil, err
+		}
+		tr.Namespace = Namespace()
+		return tr, nil
+	}
+	tr, err := build(tag)
+	if err != nil {
+		return nil, err
 	}
 
-	return yit.FromNode(doc).
-		RecurseNodes().
-		Filter(yit.StringValue).
-		Filter(yit.Union(ps...))
+	return &buildPlan{
+		imageName: imageName,
+		tag:       tag,
+		tr:        tr,
+		build:     build,
+		local:     local,
+		tooling:   opts.buildpackOptions.tooling(),
+	}, nil
 }
 
-func (opts *ResolveOptions) runLocalBuild(tr *tknv1beta1.TaskRun, binary, imageName, path, digestFile string) (name.Digest, error) {
-	args := tr.Spec.TaskSpec.Steps[len(tr.Spec.TaskSpec.Steps)-1].Args
-
-	// lets replace the context with the current dir
-	wd, err := os.Getwd()
+// buildpackQuery returns the options for the buildpack:/// reference to the path,
+// overridden by those in its query, e.g. buildpack:///path?builder=paketobuildpacks/builder:base&run-image=paketobuildpacks/run:base-cnb&env=BP_JVM_VERSION=17
+func (opts *ResolveOptions) buildpackQuery(path string, q url.Values) (buildpacks.Options, error) {
+	// The environment variables take precedence over those of project.toml.
+	env, err := keyValues(q["env"])
 	if err != nil {
-		return name.Digest{}, errs.Wrapf(err, "failed to get current working directory")
+		return buildpacks.Options{}, err
 	}
-
-	context := wd
-	if path != "" && path != "/" {
-		context = filepath.Join(wd, path)
+	bpOpts, err := opts.buildpackOptions.options(opts.Directory, path, env)
+	if err != nil {
+		return buildpacks.Options{}, err
 	}
-	for i := range args {
-		arg := args[i]
-		if strings.HasPrefix(arg, "--context=") {
-			args[i] = "--context=" + context
-		} else if strings.HasPrefix(arg, "--dockerfile=/workspace") {
-			args[i] = "--dockerfile=" + wd + arg[len("--dockerfile=/workspace"):]
+
+	for key, values := range q {
+		value := values[len(values)-1]
+		switch key {
+		case "env":
+		case "builder":
+			if _, err := name.ParseReference(value, name.WeakValidation); err != nil {
+				return bpOpts, fmt.Errorf("invalid builder: %w", err)
+			}
+			bpOpts.Builder = value
+		case "run-image":
+			if _, err := name.ParseReference(value, name.WeakValidation); err != nil {
+				return bpOpts, fmt.Errorf("invalid run-image: %w", err)
+			}
+			bpOpts.RunImage = value
+		default:
+			return bpOpts, fmt.Errorf("unsupported parameter %q", key)
 		}
 	}
-	argsText := strings.Join(args, " ")
+	return bpOpts, nil
+}
 
-	dir := filepath.Dir(digestFile)
-	err = os.MkdirAll(dir, 0760)
+func (opts *ResolveOptions) ko(ctx context.Context, sourceSteps []tknv1beta1.Step, u *url.URL) (*buildPlan, error) {
+	// The .mink.yaml of the package's directory (within the module at the root of
+	// --directory) applies to it as it does for dockerfile:/// references.
+	q, image := u.Query(), opts.ImageName
+	if dir, ok := opts.koDir(u.Host + u.Path); ok {
+		var err error
+		if q, image, err = opts.dirConfig(&url.URL{Scheme: u.Scheme, Path: dir, RawQuery: u.RawQuery}); err != nil {
+			return nil, err
+		}
+	}
+	// Resolve the image name (e.g. $DIR_NAME) the same way as for dockerfile:/// references.
+	imageName, tag, err := opts.resolveImageName(image, u.Host+u.Path)
 	if err != nil {
-		return name.Digest{}, errs.Wrapf(err, "failed to make dir %s", dir)
+		return nil, err
 	}
 
-	log.Printf("running: %s %s\n", binary, argsText)
+	local := opts.Local || opts.LocalKo
+	// The query holds our options, rather than being part of the import path.
+	importPath := *u
+	importPath.RawQuery = ""
+	koOpts, err := koQuery(ko.Options{
+		ImportPath: importPath.String(),
+		Naming:     opts.KoNaming,
+		BaseImage:  opts.KoBaseImage,
+		Platforms:  opts.platformStrings(),
+		GoProxy:    opts.GoProxy,
+		GoFlags:    opts.GoFlags,
+	}, q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %q reference: %w", u, err)
+	}
+	if !local {
+		// Our own go toolchain has its own caches.
+		koOpts.CachePVC = opts.KoCachePVC
+		koOpts.CacheImage = opts.KoCacheImage
+	} else {
+		// The image we run in-cluster has ko on the PATH, so only
+		// override the binary when we are running it ourselves.
+		koOpts.KoBinary = opts.KoBinary
+		if strings.ContainsRune(koOpts.KoBinary, filepath.Separator) {
+			// The step runs within the source directory, so resolve relative binaries against ours.
+			if koOpts.KoBinary, err = filepath.Abs(koOpts.KoBinary); err != nil {
+				return nil, err
+			}
+		}
+	}
+	tr := ko.Build(ctx, sourceSteps, tag, koOpts)
 
-	c := exec.Command(binary, args...)
+	// With naming strategies other than --bare, ko publishes beneath the image's repository.
+	if published, err := ko.Target(tag, koOpts); err != nil {
+		return nil, err
+	} else if published != tag {
+		imageName, tag = published.String(), published
+	}
+	fmt.Fprintf(opts.cmd.OutOrStderr(), "building image %s\n", imageName)
+
+	return &buildPlan{
+		imageName: imageName,
+		tag:       tag,
+		tr:        tr,
+		local:     local,
+	}, nil
+}
 
-	// Pass through our environment
-	c.Env = os.Environ()
-	c.Stderr = os.Stderr
-	c.Stdout = os.Stdout
-	c.Stdin = os.Stdin
-	err = c.Run()
-	if err != nil {
-		return name.Digest{}, errs.Wrapf(err, "failed to run command: %s %s", binary, argsText)
+// koQuery overrides the options with those in the query of a ko:// reference,
+// e.g. ko://github.com/foo/bar/cmd/baz?base-image=gcr.io/distroless/static&naming=base-import-paths
+func koQuery(koOpts ko.Options, q url.Values) (ko.Options, error) {
+	for key, values := range q {
+		value := values[len(values)-1]
+		switch key {
+		case "base-image":
+			if _, err := name.ParseReference(value, name.WeakValidation); err != nil {
+				return koOpts, fmt.Errorf("invalid base-image: %w", err)
+			}
+			koOpts.BaseImage = value
+		case "naming":
+			if !sets.NewString(ko.Namings...).Has(value) {
+				return koOpts, fmt.Errorf("unsupported naming %q, expected one of %v", value, ko.Namings)
+			}
+			koOpts.Naming = value
+		default:
+			return koOpts, fmt.Errorf("unsupported parameter %q", key)
+		}
 	}
+	return koOpts, nil
+}
 
-	data, err := ioutil.ReadFile(digestFile)
-	if err != nil {
-		return name.Digest{}, errs.Wrapf(err, "failed to read %s", digestFile)
+func (opts *ResolveOptions) refsFromDoc(doc *yaml.Node) yit.Iterator {
+	ps := make([]yit.Predicate, 0, len(opts.builders))
+
+	for k := range opts.builders {
+		ps = append(ps, yit.WithPrefix(k+"://"))
 	}
 
-	value := strings.TrimSpace(string(data))
-	return name.NewDigest(imageName + "@" + value)
+	return yit.FromNode(doc).
+		RecurseNodes().
+		Filter(yit.StringValue).
+		Filter(yit.Union(ps...))
 }
@@ -0,0 +1,320 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mattmoor/mink/pkg/builds/buildpacks"
+	"github.com/mattmoor/mink/pkg/builds/dockerfile"
+	"github.com/mattmoor/mink/pkg/builds/ko"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestReportAborted(t *testing.T) {
+	plans := map[string]*buildPlan{
+		"ko://github.com/foo/bar":   {imageName: "gcr.io/foo/bar"},
+		"dockerfile:///baz":         {imageName: "gcr.io/foo/baz"},
+		"buildpack:///qux":          {imageName: "gcr.io/foo/qux"},
+		"dockerfile:///interrupted": {imageName: "gcr.io/foo/interrupted"},
+	}
+
+	tests := []struct {
+		name      string
+		succeeded []string
+		failed    map[string]error
+		want      string
+	}{{
+		name:      "all succeeded",
+		succeeded: []string{"ko://github.com/foo/bar", "dockerfile:///baz", "buildpack:///qux", "dockerfile:///interrupted"},
+	}, {
+		name:      "failures are not aborted",
+		succeeded: []string{"ko://github.com/foo/bar", "dockerfile:///baz", "dockerfile:///interrupted"},
+		failed:    map[string]error{"buildpack:///qux": errors.New("boom")},
+	}, {
+		name:      "cancelled and not started",
+		succeeded: []string{"ko://github.com/foo/bar"},
+		failed: map[string]error{
+			"buildpack:///qux":          errors.New("boom"),
+			"dockerfile:///interrupted": fmt.Errorf("build failed: %w", context.Canceled),
+		},
+		want: "aborted 2 of 4 builds:\n" +
+			"  dockerfile:///baz (gcr.io/foo/baz)\n" +
+			"  dockerfile:///interrupted (gcr.io/foo/interrupted)\n",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var succeeded, failed sync.Map
+			for _, ref := range test.succeeded {
+				succeeded.Store(ref, "sha256:deadbeef")
+			}
+			for ref, err := range test.failed {
+				failed.Store(ref, err)
+			}
+
+			var out bytes.Buffer
+			cmd := &cobra.Command{}
+			cmd.SetOut(&out)
+			opts := &ResolveOptions{cmd: cmd}
+			opts.reportAborted(plans, &succeeded, &failed)
+			assert.Equal(t, test.want, out.String())
+		})
+	}
+}
+
+func TestDockerfileQuery(t *testing.T) {
+	// The options from the global flags.
+	base := dockerfile.Options{
+		Dockerfile: "Dockerfile",
+		Engine:     "kaniko",
+		BuildArgs:  map[string]string{"VERSION": "1.0", "DEBUG": "false"},
+		Labels:     map[string]string{"team": "a"},
+		Secrets:    []string{"npm"},
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    dockerfile.Options
+		wantErr string
+	}{{
+		name: "no query",
+		want: base,
+	}, {
+		name:  "query takes precedence over flags",
+		query: "dockerfile=Dockerfile.prod&engine=buildkit&target=prod&arg=VERSION=2.0&label=team=b&secret=pip",
+		want: dockerfile.Options{
+			Dockerfile: "Dockerfile.prod",
+			Engine:     "buildkit",
+			Target:     "prod",
+			BuildArgs:  map[string]string{"VERSION": "2.0", "DEBUG": "false"},
+			Labels:     map[string]string{"team": "b"},
+			Secrets:    []string{"npm", "pip"},
+		},
+	}, {
+		name:  "repeated args",
+		query: "arg=VERSION=2.0&arg=EXTRA=yes&arg=VERSION=3.0",
+		want: dockerfile.Options{
+			Dockerfile: "Dockerfile",
+			Engine:     "kaniko",
+			BuildArgs:  map[string]string{"VERSION": "3.0", "DEBUG": "false", "EXTRA": "yes"},
+			Labels:     map[string]string{"team": "a"},
+			Secrets:    []string{"npm"},
+		},
+	}, {
+		name:    "unsupported parameter",
+		query:   "context=foo",
+		wantErr: `unsupported parameter "context"`,
+	}, {
+		name:    "invalid engine",
+		query:   "engine=docker",
+		wantErr: `unsupported engine "docker"`,
+	}, {
+		name:    "empty dockerfile",
+		query:   "dockerfile=",
+		wantErr: "empty dockerfile",
+	}, {
+		name:    "invalid arg",
+		query:   "arg=VERSION",
+		wantErr: "expected KEY=VALUE, got: VERSION",
+	}, {
+		name:    "invalid secret",
+		query:   "secret=Not_A_Secret",
+		wantErr: `invalid secret "Not_A_Secret"`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := url.ParseQuery(test.query)
+			require.NoError(t, err)
+
+			got, err := dockerfileQuery(base, q)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	// The query must not modify the options of other references.
+	assert.Equal(t, map[string]string{"VERSION": "1.0", "DEBUG": "false"}, base.BuildArgs)
+	assert.Equal(t, []string{"npm"}, base.Secrets)
+}
+
+func TestBuildpackQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, buildpacks.ProjectFile), []byte(`
+[[build.env]]
+name = "FROM_PROJECT"
+value = "project"
+
+[[build.env]]
+name = "OVERRIDDEN"
+value = "project"
+`), 0644))
+
+	opts := &ResolveOptions{}
+	opts.Directory = dir
+	// The options from the global flags.
+	opts.buildpackOptions = buildpackOptions{
+		Builder:      "paketobuildpacks/builder:tiny",
+		OverrideFile: "overrides.toml",
+		Env:          map[string]string{"OVERRIDDEN": "flag", "FROM_FLAG": "flag"},
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		wantBuilder string
+		wantRun     string
+		wantEnv     []corev1.EnvVar
+		wantErr     string
+	}{{
+		name:        "no query",
+		wantBuilder: "paketobuildpacks/builder:tiny",
+		wantEnv: []corev1.EnvVar{
+			{Name: "FROM_FLAG", Value: "flag"},
+			{Name: "FROM_PROJECT", Value: "project"},
+			{Name: "OVERRIDDEN", Value: "flag"},
+		},
+	}, {
+		name:        "query takes precedence over flags and project.toml",
+		query:       "builder=paketobuildpacks/builder:base&run-image=paketobuildpacks/run:base-cnb&env=OVERRIDDEN=query&env=FROM_PROJECT=query",
+		wantBuilder: "paketobuildpacks/builder:base",
+		wantRun:     "paketobuildpacks/run:base-cnb",
+		wantEnv: []corev1.EnvVar{
+			{Name: "FROM_FLAG", Value: "flag"},
+			{Name: "FROM_PROJECT", Value: "query"},
+			{Name: "OVERRIDDEN", Value: "query"},
+		},
+	}, {
+		name:    "unsupported parameter",
+		query:   "engine=kaniko",
+		wantErr: `unsupported parameter "engine"`,
+	}, {
+		name:    "invalid builder",
+		query:   "builder=Not%20An%20Image",
+		wantErr: "invalid builder",
+	}, {
+		name:    "invalid run-image",
+		query:   "run-image=Not%20An%20Image",
+		wantErr: "invalid run-image",
+	}, {
+		name:    "invalid env",
+		query:   "env=FOO",
+		wantErr: "expected KEY=VALUE, got: FOO",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := url.ParseQuery(test.query)
+			require.NoError(t, err)
+
+			got, err := opts.buildpackQuery("", q)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.wantBuilder, got.Builder)
+			assert.Equal(t, test.wantRun, got.RunImage)
+			assert.Equal(t, test.wantEnv, got.Env)
+		})
+	}
+}
+
+func TestKoQuery(t *testing.T) {
+	// The options from the global flags.
+	base := ko.Options{
+		ImportPath: "ko://github.com/foo/bar/cmd/baz",
+		BaseImage:  "gcr.io/distroless/static:nonroot",
+		Naming:     "bare",
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    ko.Options
+		wantErr string
+	}{{
+		name: "no query",
+		want: base,
+	}, {
+		name:  "query takes precedence over flags",
+		query: "base-image=gcr.io/distroless/base&naming=base-import-paths",
+		want: ko.Options{
+			ImportPath: "ko://github.com/foo/bar/cmd/baz",
+			BaseImage:  "gcr.io/distroless/base",
+			Naming:     "base-import-paths",
+		},
+	}, {
+		name:  "last value wins",
+		query: "naming=preserve-import-paths&naming=base-import-paths",
+		want: ko.Options{
+			ImportPath: "ko://github.com/foo/bar/cmd/baz",
+			BaseImage:  "gcr.io/distroless/static:nonroot",
+			Naming:     "base-import-paths",
+		},
+	}, {
+		name:    "unsupported parameter",
+		query:   "engine=kaniko",
+		wantErr: `unsupported parameter "engine"`,
+	}, {
+		name:    "invalid base-image",
+		query:   "base-image=Not%20An%20Image",
+		wantErr: "invalid base-image",
+	}, {
+		name:    "invalid naming",
+		query:   "naming=random",
+		wantErr: `unsupported naming "random"`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := url.ParseQuery(test.query)
+			require.NoError(t, err)
+
+			got, err := koQuery(base, q)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
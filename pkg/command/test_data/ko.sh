@@ -0,0 +1,8 @@
+#!/usr/bin/env bash
+
+# a sample digest for testing
+digest="sha256:8e65ec4b80519d869e8d600fdf262c6e8cd3f6c7e8382406d9cb039f352a69bc"
+
+>&2 echo "fake-ko $@ building digest $digest"
+
+echo "$KO_DOCKER_REPO@$digest"
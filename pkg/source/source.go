@@ -65,15 +65,34 @@ func CreateSourceSteps(ctx context.Context, directory string, tag name.Tag, loca
 		if err != nil {
 			return nil, nil, err
 		}
-		return []tknv1beta1.Step{{
-			Container: corev1.Container{
-				Name:       "extract-bundle",
-				Image:      kontext.String(),
-				WorkingDir: "/workspace",
-			},
-		}}, []name.Reference{tag, kontext}, nil
+		return bundleSteps(kontext), []name.Reference{tag, kontext}, nil
+	}
+	return gitSteps(location), []name.Reference{tag}, nil
+}
+
+// PlanSourceSteps returns the source step(s) that CreateSourceSteps would create,
+// without bundling up the source, so that dry-runs publish nothing.  The bundle
+// is referenced by its tag, rather than by the digest it would be published at.
+func PlanSourceSteps(tag name.Tag, location *GitLocation) ([]tknv1beta1.Step, []name.Reference) {
+	if location == nil {
+		return bundleSteps(tag), []name.Reference{tag}
 	}
+	return gitSteps(location), []name.Reference{tag}
+}
+
+// bundleSteps returns the step that extracts the source bundle.
+func bundleSteps(bundle name.Reference) []tknv1beta1.Step {
+	return []tknv1beta1.Step{{
+		Container: corev1.Container{
+			Name:       "extract-bundle",
+			Image:      bundle.String(),
+			WorkingDir: "/workspace",
+		},
+	}}
+}
 
+// gitSteps returns the step that clones the source.
+func gitSteps(location *GitLocation) []tknv1beta1.Step {
 	verbose := ""
 	if location.Verbose {
 		verbose = "true"
@@ -116,5 +135,5 @@ func CreateSourceSteps(ctx context.Context, directory string, tag name.Tag, loca
 			},
 		},
 		Script: gitCloneScript,
-	}}, []name.Reference{tag}, nil
+	}}
 }